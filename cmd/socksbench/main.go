@@ -0,0 +1,182 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Command socksbench load-tests a SOCKS5 proxy: it opens sessions to a
+// target through the proxy from a number of concurrent workers and reports
+// handshake latency percentiles, throughput and the distribution of errors.
+//
+// With -bytes set, every session sends that many bytes and reads them
+// back, so the target has to be an echo server.
+//
+// Usage:
+//
+//	socksbench [-c 10] [-n 1000] [-bytes 0] [-user name -pass secret] proxy:port target:port
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+type result struct {
+	connect   time.Duration
+	handshake time.Duration
+	bytes     int64
+	err       error
+}
+
+func main() {
+	concurrency := flag.Int("c", 10, "number of concurrent sessions")
+	sessions := flag.Int("n", 1000, "total number of sessions")
+	size := flag.Int("bytes", 0, "bytes to echo through each session")
+	user := flag.String("user", "", "username for username/password authentication")
+	pass := flag.String("pass", "", "password for username/password authentication")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each session")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] proxy:port target:port\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || *concurrency < 1 || *sessions < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	proxy := &socks.Proxy{Address: flag.Arg(0), Username: *user, Password: *pass}
+	target := flag.Arg(1)
+	payload := bytes.Repeat([]byte("socksbench"), *size/10+1)[:*size]
+
+	jobs := make(chan struct{})
+	results := make(chan result, *sessions)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- session(proxy, target, payload, *timeout)
+			}
+		}()
+	}
+	for i := 0; i < *sessions; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+	report(results, time.Since(start))
+}
+
+// session dials target through the proxy and echoes payload
+func session(proxy *socks.Proxy, target string, payload []byte, timeout time.Duration) result {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c, err := proxy.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return result{err: err}
+	}
+	defer c.Close()
+	t := c.(*socks.ProxiedConn).HandshakeInfo().Timings
+	r := result{connect: t.Connect, handshake: t.Greeting + t.Auth + t.Request}
+	if len(payload) == 0 {
+		return r
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Write(payload)
+		errc <- err
+	}()
+	n, err := io.CopyN(ioutil.Discard, c, int64(len(payload)))
+	if werr := <-errc; err == nil {
+		err = werr
+	}
+	r.bytes = 2 * n
+	r.err = err
+	return r
+}
+
+func report(results chan result, elapsed time.Duration) {
+	var (
+		connect, handshake []time.Duration
+		total              int64
+		ok, failed         int
+		errs               = map[string]int{}
+	)
+	for r := range results {
+		total += r.bytes
+		if r.err != nil {
+			failed++
+			errs[classify(r.err)]++
+		} else {
+			ok++
+		}
+		if r.handshake != 0 {
+			connect = append(connect, r.connect)
+			handshake = append(handshake, r.handshake)
+		}
+	}
+
+	fmt.Printf("sessions     %d ok, %d failed in %v\n", ok, failed, elapsed.Round(time.Millisecond))
+	if len(handshake) > 0 {
+		fmt.Printf("\n%-12s %10s %10s %10s %10s\n", "latency", "p50", "p90", "p99", "max")
+		printPercentiles("connect", connect)
+		printPercentiles("handshake", handshake)
+	}
+	if total > 0 {
+		fmt.Printf("\nthroughput   %.2f MB/s\n", float64(total)/elapsed.Seconds()/1e6)
+	}
+	if failed > 0 {
+		fmt.Printf("\nerrors:\n")
+		keys := make([]string, 0, len(errs))
+		for k := range errs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %-50s %d\n", k, errs[k])
+		}
+	}
+}
+
+func printPercentiles(name string, d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	p := func(q float64) time.Duration {
+		return d[int(q*float64(len(d)-1))].Round(time.Microsecond)
+	}
+	fmt.Printf("%-12s %10v %10v %10v %10v\n", name, p(0.5), p(0.9), p(0.99), d[len(d)-1].Round(time.Microsecond))
+}
+
+// classify groups errors by reply code or cause
+func classify(err error) string {
+	var re *socks.ReplyError
+	var ne net.Error
+	var oe *net.OpError
+	switch {
+	case errors.As(err, &re):
+		return fmt.Sprintf("reply %d: %v", re.Code, re)
+	case errors.Is(err, socks.ErrAuthFailed):
+		return "authentication failed"
+	case errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.As(err, &oe) && oe.Err != nil:
+		return oe.Err.Error()
+	}
+	return err.Error()
+}
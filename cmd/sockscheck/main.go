@@ -0,0 +1,121 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Command sockscheck troubleshoots a SOCKS5 proxy: it reports which
+// authentication methods the proxy accepts, measures handshake latency and
+// tries to CONNECT to a target through it.
+//
+// Usage:
+//
+//	sockscheck [-user name -pass secret] [-timeout 10s] proxy:port target:port
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+var methods = []struct {
+	method socks.AuthMethod
+	name   string
+}{
+	{socks.AuthNone, "no authentication"},
+	{socks.AuthGSSAPI, "GSS-API"},
+	{socks.AuthUsernamePassword, "username/password"},
+}
+
+func main() {
+	user := flag.String("user", "", "username for username/password authentication")
+	pass := flag.String("pass", "", "password for username/password authentication")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each step")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] proxy:port target:port\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	proxy, target := flag.Arg(0), flag.Arg(1)
+
+	fmt.Printf("proxy %s\n\nauthentication methods:\n", proxy)
+	for _, m := range methods {
+		ok, err := probeMethod(proxy, m.method, *timeout)
+		switch {
+		case err != nil:
+			fmt.Printf("  %-20s error: %v\n", m.name, err)
+		case ok:
+			fmt.Printf("  %-20s accepted\n", m.name)
+		default:
+			fmt.Printf("  %-20s rejected\n", m.name)
+		}
+	}
+
+	fmt.Printf("\nCONNECT %s:\n", target)
+	if err := check(proxy, target, *user, *pass, *timeout); err != nil {
+		fmt.Printf("  failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// probeMethod offers the proxy a single authentication method and reports
+// whether it was chosen
+func probeMethod(proxy string, method socks.AuthMethod, timeout time.Duration) (bool, error) {
+	conn, err := net.DialTimeout("tcp", proxy, timeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write([]byte{5, 1, byte(method)})
+	if err != nil {
+		return false, err
+	}
+	var b [2]byte
+	_, err = io.ReadFull(conn, b[:])
+	if err != nil {
+		return false, err
+	}
+	if b[0] != 5 {
+		return false, fmt.Errorf("unexpected version %d", b[0])
+	}
+	return b[1] == byte(method), nil
+}
+
+func check(proxy, target, user, pass string, timeout time.Duration) error {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", proxy, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	connected := time.Now()
+	fmt.Printf("  tcp connect     %v\n", connected.Sub(start))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c := socks.Client{Username: user, Password: pass}
+	rep, err := c.Handshake(ctx, conn, socks.CommandConnect, target)
+	if rep != nil {
+		fmt.Printf("  handshake       %v\n", time.Since(connected))
+		fmt.Printf("    greeting      %v\n", rep.Timings.Greeting)
+		if rep.Timings.Auth != 0 {
+			fmt.Printf("    auth          %v\n", rep.Timings.Auth)
+		}
+		fmt.Printf("    request       %v\n", rep.Timings.Request)
+		fmt.Printf("  auth method     %d\n", rep.Method)
+		fmt.Printf("  reply code      %d\n", rep.Code)
+		fmt.Printf("  bound address   %v\n", &rep.BoundAddr)
+	}
+	return err
+}
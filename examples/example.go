@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 
 	"github.com/akabos/go-socks/socks"
@@ -19,10 +18,7 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-	tr := &http.Transport{
-		Dial: proxy.Dial,
-	}
-	client := &http.Client{Transport: tr}
+	client := proxy.HTTPClient()
 	resp, err := client.Get("http://httpbin.org/get")
 	if err != nil {
 		log.Panic(err.Error())
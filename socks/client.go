@@ -0,0 +1,422 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Command is a SOCKS5 request command
+type Command byte
+
+// Request commands
+const (
+	CommandConnect      Command = commandTCPConnect
+	CommandBind         Command = commandTCPBind
+	CommandUDPAssociate Command = commandUDPAssociate
+)
+
+// Addr is an address carried in SOCKS requests and replies. Either Name
+// or IP is set, depending on the address type.
+type Addr struct {
+	Name string
+	IP   net.IP
+	Port int
+}
+
+// Network returns name of the address network
+func (a *Addr) Network() string {
+	return "socks"
+}
+
+func (a *Addr) String() string {
+	host := a.Name
+	if host == "" {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
+
+// Request is a command request to be sent to the proxy
+type Request struct {
+	Command Command
+	Addr    Addr
+}
+
+// Reply is a proxy reply to a command request
+type Reply struct {
+	Method    AuthMethod
+	Code      byte
+	BoundAddr Addr
+	Timings   HandshakeTimings
+}
+
+// HandshakeTimings are durations of the steps of establishing a proxied
+// connection, steps that didn't happen are zero
+type HandshakeTimings struct {
+	// Connect is the time to connect to the proxy, it's only measured when
+	// the package dials the proxy itself
+	Connect time.Duration
+	// Greeting is the round trip of the authentication method selection
+	Greeting time.Duration
+	// Auth is the round trip of the authentication subnegotiation
+	Auth time.Duration
+	// Request is the time from sending the command request to the reply
+	Request time.Duration
+}
+
+// Client performs SOCKS5 negotiation over connections established by the
+// caller
+type Client struct {
+	// Username and Password are sent as is, each limited to 255 bytes
+	Username string
+	Password string
+
+	// Trace, if set, receives a hex dump of the handshake traffic with
+	// credentials masked
+	Trace io.Writer
+
+	// Quirks lists protocol deviations tolerated from the proxy
+	Quirks Quirks
+
+	// Methods lists authentication methods offered to the proxy in order
+	// of preference. If empty, private methods from Extensions are offered
+	// first, then no authentication and username/password if Username is
+	// set. GSS-API may be offered, but the handshake fails if the proxy
+	// picks it.
+	Methods []AuthMethod
+
+	// OnRequest, if set, is called with the request before anything is
+	// sent to the proxy. It may modify the request, or veto it by returning
+	// an error, which is then returned by Handshake.
+	OnRequest func(req *Request) error
+
+	// Extensions, if set, provides private authentication methods
+	Extensions *Extensions
+}
+
+// Handshake authenticates with the proxy over conn and requests command
+// for the target address. The conn is neither closed nor wrapped, so the
+// caller keeps full ownership of it.
+//
+// If ctx has a deadline, it replaces any deadline set on conn for the
+// duration of the handshake and is cleared afterwards, so the caller has
+// to set its own deadline again. Without a deadline in ctx, deadlines of
+// conn are left untouched.
+//
+// A reply is returned along with an error when the proxy rejects the
+// request. For CommandBind only the first reply is read, use ReadReply to
+// wait for the second one.
+func (c Client) Handshake(ctx context.Context, conn net.Conn, command Command, target string) (*Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if c.OnRequest != nil {
+		command, host, port, err = c.request(command, host, port)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Username) > maxCredentialLen || len(c.Password) > maxCredentialLen {
+		return nil, ErrCredentialsTooLong
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+	if c.Trace != nil {
+		conn = &traceConn{Conn: conn, w: c.Trace}
+	}
+
+	// Large enough for the longest possible reply regardless of request size
+	buf := make([]byte, 7+maxDomainLen+len(c.Username)+len(c.Password))
+
+	rep := &Reply{}
+	err = c.authenticate(conn, buf, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	// Command request
+
+	buf = append(buf[:0], protocolVersion, byte(command), 0) // 0 = reserved
+	buf = appendAddr(buf, host, port)
+
+	start := time.Now()
+	_, err = conn.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = readReply(conn, buf, rep, c.Quirks)
+	if err != nil {
+		return nil, err
+	}
+	rep.Timings.Request = time.Since(start)
+	return rep, rep.err()
+}
+
+// request passes the request through OnRequest and returns the command and
+// target to send, the target is validated again
+func (c Client) request(command Command, host string, port int) (Command, string, int, error) {
+	req := &Request{Command: command, Addr: Addr{Name: host, Port: port}}
+	if ip := net.ParseIP(host); ip != nil {
+		req.Addr = Addr{IP: ip, Port: port}
+	}
+	if err := c.OnRequest(req); err != nil {
+		return 0, "", 0, err
+	}
+	host, port, err := splitTarget(req.Addr.String())
+	return req.Command, host, port, err
+}
+
+// ReadReply reads a single proxy reply from r, such as the second reply to
+// CommandBind sent once the remote host connects. A reply is returned
+// along with an error when it reports a failure.
+func ReadReply(r io.Reader) (*Reply, error) {
+	rep := &Reply{}
+	err := readReply(r, make([]byte, 0, 7+maxDomainLen), rep, 0)
+	if err != nil {
+		return nil, err
+	}
+	return rep, rep.err()
+}
+
+func (rep *Reply) info() HandshakeInfo {
+	return HandshakeInfo{Method: rep.Method, BoundAddr: rep.BoundAddr, Timings: rep.Timings}
+}
+
+func (rep *Reply) err() error {
+	if rep.Code != statusRequestGranted {
+		return statusErrors[rep.Code]
+	}
+	return nil
+}
+
+// authenticate sends greeting and performs subnegotiation for the method
+// chosen by the proxy, recording the method and timings into rep
+func (c Client) authenticate(conn net.Conn, buf []byte, rep *Reply) error {
+	methods := []byte{}
+	for _, m := range c.Extensions.Methods() {
+		methods = append(methods, byte(m))
+	}
+	methods = append(methods, authNone)
+	if c.Username != "" {
+		methods = append(methods, authUsernamePassword)
+	}
+	if len(c.Methods) > 0 {
+		methods = methods[:0]
+		for _, m := range c.Methods {
+			methods = append(methods, byte(m))
+		}
+	}
+	if len(methods) > maxMethods {
+		return ErrTooManyMethods
+	}
+
+	buf = append(buf[:0], protocolVersion, byte(len(methods)))
+	buf = append(buf, methods...)
+
+	start := time.Now()
+	_, err := conn.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	// Server's auth choice
+
+	_, err = io.ReadFull(conn, buf[:2])
+	if err != nil {
+		return err
+	}
+	rep.Timings.Greeting = time.Since(start)
+	if buf[0] != protocolVersion {
+		return malformed(buf[:2])
+	}
+
+	if buf[1] != authUnavailable && bytes.IndexByte(methods, buf[1]) < 0 {
+		return malformed(buf[:2]) // method that wasn't offered
+	}
+
+	rep.Method = AuthMethod(buf[1])
+	switch buf[1] {
+	default:
+		if f := c.Extensions.Method(rep.Method); f != nil {
+			start = time.Now()
+			if err := f(conn); err != nil {
+				return err
+			}
+			rep.Timings.Auth = time.Since(start)
+			return nil
+		}
+		// Offered but not implemented, such as GSS-API
+		return ErrNoAcceptableAuthMethod
+	case authUnavailable:
+		return ErrNoAcceptableAuthMethod
+	case authUsernamePassword:
+		buf = buf[:3+len(c.Username)+len(c.Password)]
+		buf[0] = 1 // version
+		buf[1] = byte(len(c.Username))
+		copy(buf[2:], c.Username)
+		buf[2+len(c.Username)] = byte(len(c.Password))
+		copy(buf[3+len(c.Username):], c.Password)
+
+		if tc, ok := conn.(*traceConn); ok {
+			tc.redact = redactCredentials
+		}
+		start = time.Now()
+		_, err = conn.Write(buf)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, buf[:2])
+		if err != nil {
+			return err
+		}
+		rep.Timings.Auth = time.Since(start)
+
+		if buf[0] != 1 && !c.Quirks.has(QuirkAuthReplyVersion) { // version
+			return malformed(buf[:2])
+		} else if buf[1] != 0 { // 0 = succes, else auth failed
+			return ErrAuthFailed
+		}
+	case authNone:
+		// Do nothing
+	}
+	return nil
+}
+
+// readReply reads proxy reply into rep, buf must fit the longest reply
+func readReply(r io.Reader, buf []byte, rep *Reply, q Quirks) error {
+	buf = buf[:4]
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return err
+	}
+	if buf[0] != protocolVersion {
+		return malformed(buf[:4])
+	}
+	if buf[2] != 0 && !q.has(QuirkReplyReserved) { // 0 = reserved
+		return malformed(buf[:4])
+	}
+	if buf[1] != statusRequestGranted && statusErrors[buf[1]] == nil {
+		return malformed(buf[:4])
+	}
+	rep.Code = buf[1]
+
+	off, n := 4, 4
+	switch buf[3] {
+	default:
+		return malformed(buf[:4])
+	case addressTypeIPv4:
+		n += net.IPv4len
+	case addressTypeIPv6:
+		n += net.IPv6len
+	case addressTypeDomain:
+		buf = buf[:5]
+		_, err = io.ReadFull(r, buf[4:])
+		if err != nil {
+			return err
+		}
+		if buf[4] == 0 {
+			return malformed(buf[:5])
+		}
+		off = 5
+		n += 1 + int(buf[4])
+	}
+
+	buf = buf[:n+2]
+	_, err = io.ReadFull(r, buf[off:])
+	if err != nil {
+		return err
+	}
+	if buf[3] == addressTypeDomain {
+		rep.BoundAddr.Name = string(buf[5:n])
+	} else {
+		rep.BoundAddr.IP = net.IP(append([]byte(nil), buf[4:n]...))
+	}
+	rep.BoundAddr.Port = int(buf[n])<<8 | int(buf[n+1])
+	return nil
+}
+
+// splitTarget splits and validates "host:port" destination address
+func splitTarget(addr string) (string, int, error) {
+	host, strPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(strPort)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(host) > maxDomainLen {
+		return "", 0, ErrHostTooLong
+	}
+	if port < 0 || port > 0xffff {
+		return "", 0, &net.AddrError{Err: "invalid port", Addr: addr}
+	}
+	return host, port, nil
+}
+
+// family returns address family network is restricted to, "4", "6" or
+// empty if any
+func family(network string) string {
+	switch f := network[len(network)-1:]; f {
+	case "4", "6":
+		return f
+	}
+	return ""
+}
+
+// inFamily reports whether ip belongs to family, any IP does to empty one
+func inFamily(ip net.IP, family string) bool {
+	return family == "" || (ip.To4() != nil) == (family == "4")
+}
+
+// checkFamily returns error if host of addr is an IP address of family
+// other than network is restricted to. Names are left to be checked later.
+func checkFamily(network, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && !inFamily(ip, family(network)) {
+		return &net.AddrError{Err: "address family mismatch", Addr: addr}
+	}
+	return nil
+}
+
+// resolve replaces host name in addr with its first address of the family
+// required by network, such as IPv4 for "tcp4". An IP address of the
+// wrong family is an error.
+func resolve(ctx context.Context, network, addr string) (string, error) {
+	if err := checkFamily(network, addr); err != nil {
+		return "", err
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+family(network), host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
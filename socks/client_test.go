@@ -0,0 +1,260 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// maxReplyLen is the length of a reply with the longest domain name
+const maxReplyLen = 4 + 1 + maxDomainLen + 2
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		addr  string
+		err   error
+	}{
+		{"ipv4", []byte{5, 0, 0, 1, 10, 0, 0, 1, 0, 80}, "10.0.0.1:80", nil},
+		{"ipv6", append(append([]byte{5, 0, 0, 4}, make([]byte, 15)...), 1, 1, 187), "[::1]:443", nil},
+		{"domain", []byte{5, 0, 0, 3, 3, 'f', 'o', 'o', 0, 80}, "foo:80", nil},
+		{"refused", []byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}, "0.0.0.0:0", nil},
+		{"version", []byte{4, 0, 0, 1, 0, 0, 0, 0, 0, 0}, "", ErrMalformedReply},
+		{"reserved", []byte{5, 0, 1, 1, 0, 0, 0, 0, 0, 0}, "", ErrMalformedReply},
+		{"unknown code", []byte{5, 9, 0, 1, 0, 0, 0, 0, 0, 0}, "", ErrMalformedReply},
+		{"unknown type", []byte{5, 0, 0, 2, 0, 0, 0, 0, 0, 0}, "", ErrMalformedReply},
+		{"empty domain", []byte{5, 0, 0, 3, 0, 0, 80}, "", ErrMalformedReply},
+		{"truncated", []byte{5, 0, 0, 1, 10, 0}, "", io.ErrUnexpectedEOF},
+		{"truncated domain", []byte{5, 0, 0, 3, 200, 'a'}, "", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rep Reply
+			err := readReply(bytes.NewReader(tt.reply), make([]byte, 10, maxReplyLen), &rep, 0)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err == nil && rep.BoundAddr.String() != tt.addr {
+				t.Errorf("got address %v, want %v", &rep.BoundAddr, tt.addr)
+			}
+		})
+	}
+}
+
+func TestMalformedReplyError(t *testing.T) {
+	err := malformed([]byte{5, 0, 1})
+	if !errors.Is(err, ErrMalformedReply) || !errors.Is(err, ErrInvalidProxyResponse) {
+		t.Errorf("%v doesn't match sentinel errors", err)
+	}
+	var e *MalformedReplyError
+	if !errors.As(err, &e) || !bytes.Equal(e.Reply, []byte{5, 0, 1}) {
+		t.Errorf("reply bytes not preserved: %v", err)
+	}
+}
+
+func FuzzReadReply(f *testing.F) {
+	f.Add([]byte{5, 0, 0, 1, 10, 0, 0, 1, 0, 80})
+	f.Add([]byte{5, 0, 0, 3, 3, 'f', 'o', 'o', 0, 80})
+	f.Add(append([]byte{5, 0, 0, 4}, make([]byte, 18)...))
+	f.Add([]byte{5, 0, 0, 3, 255})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var rep Reply
+		err := readReply(bytes.NewReader(b), make([]byte, 4, maxReplyLen), &rep, 0)
+		if err != nil {
+			return
+		}
+		if rep.BoundAddr.Name == "" && rep.BoundAddr.IP == nil {
+			t.Errorf("accepted reply % x without bound address", b)
+		}
+		if rep.BoundAddr.Port < 0 || rep.BoundAddr.Port > 0xffff {
+			t.Errorf("port %d out of range", rep.BoundAddr.Port)
+		}
+	})
+}
+
+func TestHandshake(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "u", "p"
+
+	conn, err := net.Dial("tcp", fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := Client{Username: "u", Password: "p"}
+	rep, err := c.Handshake(context.Background(), conn, CommandConnect, echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Method != AuthUsernamePassword {
+		t.Errorf("method is %v", rep.Method)
+	}
+	if rep.BoundAddr.IP == nil || rep.BoundAddr.Port == 0 {
+		t.Errorf("bound address is %v", &rep.BoundAddr)
+	}
+	if tm := rep.Timings; tm.Greeting <= 0 || tm.Auth <= 0 || tm.Request <= 0 || tm.Connect != 0 {
+		t.Errorf("timings are %+v", tm)
+	}
+
+	// Connection is left open and usable
+	conn.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
+
+func TestHandshakeKeepsDeadline(t *testing.T) {
+	fake := newFakeProxy(t)
+	conn, err := net.Dial("tcp", fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// An already expired deadline must survive a handshake without one
+	conn.SetDeadline(time.Now().Add(-time.Second))
+	_, err = Client{}.Handshake(context.Background(), conn, CommandConnect, "127.0.0.1:1")
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestReadReplyBind(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		b := make([]byte, 64)
+		io.ReadFull(server, b[:3])
+		server.Write([]byte{5, 0})
+		io.ReadFull(server, b[:10])
+		server.Write([]byte{5, 0, 0, 1, 10, 0, 0, 1, 0x1f, 0x90})
+		server.Write([]byte{5, 0, 0, 1, 10, 0, 0, 2, 0x30, 0x39})
+	}()
+
+	rep, err := Client{}.Handshake(context.Background(), client, CommandBind, "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.BoundAddr.String() != "10.0.0.1:8080" {
+		t.Errorf("bound address is %v", &rep.BoundAddr)
+	}
+	rep, err = ReadReply(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.BoundAddr.String() != "10.0.0.2:12345" {
+		t.Errorf("peer address is %v", &rep.BoundAddr)
+	}
+}
+
+func TestHandshakeMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		methods  []AuthMethod
+		user     string
+		greeting []byte
+		choice   byte
+		err      error
+	}{
+		{"default", nil, "", []byte{5, 1, 0}, authNone, nil},
+		{"default with user", nil, "u", []byte{5, 2, 0, 2}, authUsernamePassword, nil},
+		{"password only", []AuthMethod{AuthUsernamePassword}, "u", []byte{5, 1, 2}, authUsernamePassword, nil},
+		{"gssapi chosen", []AuthMethod{AuthGSSAPI, AuthUsernamePassword}, "u", []byte{5, 2, 1, 2}, authGssAPI, ErrNoAcceptableAuthMethod},
+		{"not offered", []AuthMethod{AuthUsernamePassword}, "u", []byte{5, 1, 2}, authNone, ErrMalformedReply},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			greeting := make(chan []byte, 1)
+			// The server may outlive the subtest when the handshake fails
+			// early, so it must not touch tt
+			choice := tt.choice
+			go func() {
+				defer server.Close()
+				b := make([]byte, 512)
+				io.ReadFull(server, b[:2])
+				io.ReadFull(server, b[2:2+b[1]])
+				greeting <- append([]byte(nil), b[:2+b[1]]...)
+				server.Write([]byte{5, choice})
+				if choice == authUsernamePassword {
+					io.ReadFull(server, b[:2])
+					ulen := int(b[1])
+					io.ReadFull(server, b[:ulen+1])
+					io.ReadFull(server, b[:b[ulen]])
+					server.Write([]byte{1, 0})
+				}
+				io.ReadFull(server, b[:10])
+				server.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			}()
+
+			c := Client{Username: tt.user, Password: "p", Methods: tt.methods}
+			_, err := c.Handshake(context.Background(), client, CommandConnect, "10.0.0.1:80")
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if g := <-greeting; !bytes.Equal(g, tt.greeting) {
+				t.Errorf("greeting is % x, want % x", g, tt.greeting)
+			}
+		})
+	}
+}
+
+func TestHandshakeOnRequest(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	veto := errors.New("not allowed")
+	p.OnRequest = func(req *Request) error {
+		if req.Command != CommandConnect {
+			t.Errorf("command is %v", req.Command)
+		}
+		switch req.Addr.Name {
+		case "echo.test":
+			req.Addr = Addr{IP: net.IPv4(127, 0, 0, 1), Port: echo.Addr().(*net.TCPAddr).Port}
+			return nil
+		case "long.test":
+			req.Addr.Name = string(bytes.Repeat([]byte("x"), 256))
+			return nil
+		}
+		return veto
+	}
+
+	c, err := p.Dial("tcp", "echo.test:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if host, port := c.(*ProxiedConn).Target(); net.JoinHostPort(host, itoa(port)) != echo.Addr().String() {
+		t.Errorf("target is %v:%v", host, port)
+	}
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	if _, err := p.Dial("tcp", "blocked.test:80"); !errors.Is(err, veto) {
+		t.Errorf("vetoed dial: got %v", err)
+	}
+	if _, err := p.Dial("tcp", "long.test:80"); !errors.Is(err, ErrHostTooLong) {
+		t.Errorf("rewritten to long name: got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+// Auth contains authentication parameters, same as in
+// golang.org/x/net/proxy
+type Auth struct {
+	User, Password string
+}
+
+// SOCKS5 returns proxy dialing through the SOCKS5 proxy at addr, reached
+// with forward over network. It mirrors SOCKS5 from golang.org/x/net/proxy,
+// so call sites can be migrated by changing the import path. As there, addr
+// isn't resolved until dial time, leaving resolution to forward.
+func SOCKS5(network, addr string, auth *Auth, forward ForwardDialer) (*Proxy, error) {
+	addr, err := withDefaultPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{Network: network, Address: addr, Forward: forward}
+	if auth != nil {
+		p.Username = auth.User
+		p.Password = auth.Password
+	}
+	return p, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"net"
+	"testing"
+)
+
+// recordingDialer dials target regardless of the address asked for and
+// records what it was asked
+type recordingDialer struct {
+	target  string
+	network string
+	addr    string
+}
+
+func (d *recordingDialer) Dial(network, addr string) (net.Conn, error) {
+	d.network, d.addr = network, addr
+	return net.Dial("tcp", d.target)
+}
+
+func TestSOCKS5(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "u", "p"
+
+	fwd := &recordingDialer{target: fake.Addr()}
+	p, err := SOCKS5("tcp4", "proxy.invalid:1080", &Auth{User: "u", Password: "p"}, fwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if fwd.network != "tcp4" || fwd.addr != "proxy.invalid:1080" {
+		t.Errorf("forward asked for %s %s", fwd.network, fwd.addr)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ProxyConfig is declarative proxy configuration meant to be decoded from
+// JSON, YAML or similar configuration files
+type ProxyConfig struct {
+	// Address is the primary endpoint, port 1080 is assumed if omitted
+	Address string `json:"address" yaml:"address"`
+	// Backup lists failover endpoints tried in order
+	Backup []string `json:"backup,omitempty" yaml:"backup,omitempty"`
+
+	Username     string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password     string `json:"password,omitempty" yaml:"password,omitempty"`
+	TorIsolation bool   `json:"tor_isolation,omitempty" yaml:"tor_isolation,omitempty"`
+}
+
+// FieldError is a problem with a configuration field
+type FieldError struct {
+	// Field is the path to the field as named in configuration files,
+	// such as "backup[1]"
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError lists all problems found in configuration
+type ConfigError []*FieldError
+
+func (e ConfigError) Error() string {
+	s := make([]string, len(e))
+	for i, fe := range e {
+		s[i] = fe.Error()
+	}
+	return "invalid proxy configuration: " + strings.Join(s, "; ")
+}
+
+// Validate checks the configuration without resolving or connecting to
+// anything and returns ConfigError listing every problem found
+func (c *ProxyConfig) Validate() error {
+	var errs ConfigError
+	add := func(field string, err error) {
+		errs = append(errs, &FieldError{Field: field, Err: err})
+	}
+
+	seen := map[string]string{}
+	check := func(field, addr string) {
+		if strings.TrimSpace(addr) == "" {
+			add(field, errors.New("missing proxy address"))
+			return
+		}
+		a, err := withDefaultPort(strings.TrimSpace(addr))
+		if err != nil {
+			add(field, err)
+			return
+		}
+		if prev, ok := seen[a]; ok {
+			add(field, errors.New("duplicates "+prev))
+			return
+		}
+		seen[a] = field
+	}
+	check("address", c.Address)
+	for i, addr := range c.Backup {
+		check("backup["+strconv.Itoa(i)+"]", addr)
+	}
+
+	if len(c.Username) > maxCredentialLen {
+		add("username", ErrCredentialsTooLong)
+	}
+	if len(c.Password) > maxCredentialLen {
+		add("password", ErrCredentialsTooLong)
+	}
+	if c.TorIsolation && (c.Username != "" || c.Password != "") {
+		add("tor_isolation", errors.New("conflicts with username and password"))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Proxy validates the configuration and builds proxy from it. Endpoints
+// are resolved as by NewProxyList.
+func (c *ProxyConfig) Proxy() (*Proxy, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := NewProxyList(append([]string{c.Address}, c.Backup...))
+	if err != nil {
+		return nil, err
+	}
+	p.Username = c.Username
+	p.Password = c.Password
+	p.TorIsolation = c.TorIsolation
+	return p, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProxyConfig(t *testing.T) {
+	var c ProxyConfig
+	err := json.Unmarshal([]byte(`{
+		"address": "127.0.0.1",
+		"backup": ["127.0.0.2:1081"],
+		"username": "u",
+		"password": "p"
+	}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.Proxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Addr.String() != "127.0.0.1:1080" || len(p.Backup) != 1 || p.Backup[0].String() != "127.0.0.2:1081" {
+		t.Errorf("endpoints are %v, %v", p.Addr, p.Backup)
+	}
+	if p.Username != "u" || p.Password != "p" {
+		t.Errorf("credentials are %q %q", p.Username, p.Password)
+	}
+
+	for _, c := range []ProxyConfig{
+		{},
+		{Address: "127.0.0.1", Username: "u", TorIsolation: true},
+		{Address: "127.0.0.1", Backup: []string{""}},
+	} {
+		if _, err := c.Proxy(); err == nil {
+			t.Errorf("%+v: expected error", c)
+		}
+	}
+}
+
+func TestProxyConfigValidate(t *testing.T) {
+	c := ProxyConfig{
+		Address:      "127.0.0.1",
+		Backup:       []string{"127.0.0.2", "", "127.0.0.1:1080", "1.2.3.4:"},
+		Username:     strings.Repeat("u", 256),
+		TorIsolation: true,
+	}
+	err := c.Validate()
+	var ce ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got %v", err)
+	}
+	var fields []string
+	for _, fe := range ce {
+		fields = append(fields, fe.Field)
+	}
+	want := []string{"backup[1]", "backup[2]", "backup[3]", "username", "tor_isolation"}
+	if strings.Join(fields, " ") != strings.Join(want, " ") {
+		t.Errorf("problems in %v, want %v", fields, want)
+	}
+	if !errors.Is(ce[3], ErrCredentialsTooLong) {
+		t.Errorf("username problem is %v", ce[3])
+	}
+
+	c = ProxyConfig{Address: "proxy.example", Backup: []string{"[::1]:1081"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("valid configuration: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// HandshakeInfo describes the negotiation that established a connection
+type HandshakeInfo struct {
+	// Version is the protocol version spoken to the proxy
+	Version Version
+	// Method is the authentication method chosen by the proxy
+	Method AuthMethod
+	// BoundAddr is the address reported by the proxy for its side of the
+	// connection, some proxies report a domain name rather than an IP
+	BoundAddr Addr
+	// Timings tell how long establishing the connection took, which helps
+	// to tell a slow proxy from a slow destination
+	Timings HandshakeTimings
+}
+
+// ProxiedConn is a connection tunneled through SOCKS proxy. Connections
+// returned by Dial are of this type.
+type ProxiedConn struct {
+	net.Conn
+
+	host string
+	port int
+	info HandshakeInfo
+}
+
+// Target returns destination host and port as requested from the proxy,
+// or the peer address for connections accepted by Proxy.Listen
+func (c *ProxiedConn) Target() (string, int) {
+	return c.host, c.port
+}
+
+// Proxy returns address of the proxy the connection goes through
+func (c *ProxiedConn) Proxy() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// AuthMethod returns authentication method negotiated with the proxy
+func (c *ProxiedConn) AuthMethod() AuthMethod {
+	return c.info.Method
+}
+
+// HandshakeInfo returns details of the negotiation with the proxy
+func (c *ProxiedConn) HandshakeInfo() HandshakeInfo {
+	return c.info
+}
+
+// NetConn returns the underlying connection to the proxy
+func (c *ProxiedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite shuts down the writing side of the underlying connection if
+// it supports half-close
+func (c *ProxiedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.unsupported("close")
+}
+
+// CloseRead shuts down the reading side of the underlying connection if
+// it supports half-close
+func (c *ProxiedConn) CloseRead() error {
+	if cr, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return cr.CloseRead()
+	}
+	return c.unsupported("close")
+}
+
+// ReadFrom implements io.ReaderFrom, letting the underlying connection use
+// its fast path, such as splice on Linux
+func (c *ProxiedConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+// WriteTo implements io.WriterTo, letting the underlying connection use
+// its fast path
+func (c *ProxiedConn) WriteTo(w io.Writer) (int64, error) {
+	if wt, ok := c.Conn.(io.WriterTo); ok {
+		return wt.WriteTo(w)
+	}
+	return io.Copy(w, c.Conn)
+}
+
+func (c *ProxiedConn) unsupported(op string) error {
+	return &net.OpError{
+		Op:     op,
+		Net:    c.Conn.LocalAddr().Network(),
+		Source: c.Conn.LocalAddr(),
+		Addr:   c.Conn.RemoteAddr(),
+		Err:    errors.New("half-close not supported"),
+	}
+}
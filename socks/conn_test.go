@@ -0,0 +1,101 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProxiedConnCloseWrite(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pc := c.(*ProxiedConn)
+	if host, port := pc.Target(); net.JoinHostPort(host, itoa(port)) != echo.Addr().String() {
+		t.Errorf("target is %v:%v", host, port)
+	}
+	if pc.AuthMethod() != AuthNone {
+		t.Errorf("auth method is %v", pc.AuthMethod())
+	}
+	if tm := pc.HandshakeInfo().Timings; tm.Connect <= 0 || tm.Greeting <= 0 || tm.Auth != 0 || tm.Request <= 0 {
+		t.Errorf("timings are %+v", tm)
+	}
+
+	if _, err := pc.ReadFrom(strings.NewReader("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// Echo server closes its side once it sees our EOF, so the read below
+	// only finishes if half-close made it through the tunnel
+	if err := c.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Errorf("read %q", b)
+	}
+}
+
+func TestProxiedConnHalfCloseUnsupported(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	pc := &ProxiedConn{Conn: a}
+	if err := pc.CloseWrite(); err == nil {
+		t.Error("expected error for net.Pipe")
+	}
+	go b.Write([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(pc, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("read %q, %v", buf, err)
+	}
+}
+
+func TestHandshakeInfoBoundAddr(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		addr  Addr
+	}{
+		{"ipv4", []byte{5, 0, 0, 1, 10, 0, 0, 1, 0x1f, 0x90}, Addr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}},
+		{"ipv6", append(append([]byte{5, 0, 0, 4}, net.ParseIP("2001:db8::1")...), 0, 80), Addr{IP: net.ParseIP("2001:db8::1"), Port: 80}},
+		{"domain", append(append([]byte{5, 0, 0, 3, 15}, "egress.example."...), 1, 187), Addr{Name: "egress.example.", Port: 443}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProxy(t)
+			fake.reply = tt.reply
+			p, err := NewProxy(fake.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := p.Dial("tcp", "example.com:80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			got := c.(*ProxiedConn).HandshakeInfo().BoundAddr
+			if got.Name != tt.addr.Name || !got.IP.Equal(tt.addr.IP) || got.Port != tt.addr.Port {
+				t.Errorf("bound address is %v, want %v", &got, &tt.addr)
+			}
+		})
+	}
+}
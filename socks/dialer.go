@@ -5,13 +5,15 @@
 package socks
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"sync"
+	"time"
 )
 
 const (
@@ -19,6 +21,10 @@ const (
 
 	defaultPort = 1080
 
+	maxDomainLen     = 255
+	maxMethods       = 255
+	maxCredentialLen = 255
+
 	authNone             = 0
 	authGssAPI           = 1
 	authUsernamePassword = 2
@@ -43,33 +49,151 @@ const (
 	statusAddressTypeNotSupported = 8
 )
 
+// Reply codes
+const (
+	ReplySucceeded               = statusRequestGranted
+	ReplyGeneralFailure          = statusGeneralFailure
+	ReplyConnectionNotAllowed    = statusConnectionNotAllowed
+	ReplyNetworkUnreachable      = statusNetworkUnreachable
+	ReplyHostUnreachable         = statusHostUnreachable
+	ReplyConnectionRefused       = statusConnectionRefused
+	ReplyTTLExpired              = statusTTLExpired
+	ReplyCommandNotSupported     = statusCommandNotSupport
+	ReplyAddressTypeNotSupported = statusAddressTypeNotSupported
+)
+
+// AuthMethod is a SOCKS5 authentication method
+type AuthMethod byte
+
+// Authentication methods
+const (
+	AuthNone             AuthMethod = authNone
+	AuthGSSAPI           AuthMethod = authGssAPI
+	AuthUsernamePassword AuthMethod = authUsernamePassword
+)
+
 // Error definitions
 var (
 	ErrAuthFailed             = errors.New("authentication failed")
 	ErrInvalidProxyResponse   = errors.New("invalid proxy response")
 	ErrNoAcceptableAuthMethod = errors.New("no acceptable authentication method")
 	ErrConnUsed               = errors.New("connection already used")
+	ErrHostTooLong            = errors.New("host name too long")
+	ErrUnsupportedNetwork     = errors.New("unsupported network")
+	ErrMalformedReply         = errors.New("malformed proxy reply")
+	ErrTooManyMethods         = errors.New("too many authentication methods")
+	ErrCredentialsTooLong     = errors.New("username or password longer than 255 bytes")
+	ErrNotPrivate             = errors.New("method outside of private range")
+	ErrRequestRejected        = errors.New("request rejected or failed")
+	ErrIdentFailed            = errors.New("identd verification failed")
+	ErrMalformedRequest       = errors.New("malformed client request")
+	ErrServerClosed           = errors.New("server closed")
 
 	statusErrors = map[byte]error{
-		statusGeneralFailure:          errors.New("general failure"),
-		statusConnectionNotAllowed:    errors.New("connection not allowed by ruleset"),
-		statusNetworkUnreachable:      errors.New("network unreachable"),
-		statusHostUnreachable:         errors.New("host unreachable"),
-		statusConnectionRefused:       errors.New("connection refused by destination host"),
-		statusTTLExpired:              errors.New("TTL expired"),
-		statusCommandNotSupport:       errors.New("command not supported / protocol error"),
-		statusAddressTypeNotSupported: errors.New("address type not supported"),
+		statusGeneralFailure:          &ReplyError{statusGeneralFailure, "general failure"},
+		statusConnectionNotAllowed:    &ReplyError{statusConnectionNotAllowed, "connection not allowed by ruleset"},
+		statusNetworkUnreachable:      &ReplyError{statusNetworkUnreachable, "network unreachable"},
+		statusHostUnreachable:         &ReplyError{statusHostUnreachable, "host unreachable"},
+		statusConnectionRefused:       &ReplyError{statusConnectionRefused, "connection refused by destination host"},
+		statusTTLExpired:              &ReplyError{statusTTLExpired, "TTL expired"},
+		statusCommandNotSupport:       &ReplyError{statusCommandNotSupport, "command not supported / protocol error"},
+		statusAddressTypeNotSupported: &ReplyError{statusAddressTypeNotSupported, "address type not supported"},
 	}
 )
 
+// ReplyError is returned when the proxy rejects a request. It implements
+// net.Error, so generic retry logic can tell transient failures apart.
+type ReplyError struct {
+	// Code is the reply code sent by the proxy
+	Code byte
+
+	msg string
+}
+
+func (e *ReplyError) Error() string {
+	return e.msg
+}
+
+// Timeout reports whether the proxy gave up on reaching the destination
+func (e *ReplyError) Timeout() bool {
+	return e.Code == statusTTLExpired
+}
+
+// Temporary reports whether the request may succeed if retried later
+func (e *ReplyError) Temporary() bool {
+	switch e.Code {
+	case statusGeneralFailure, statusNetworkUnreachable, statusHostUnreachable, statusTTLExpired:
+		return true
+	}
+	return false
+}
+
+// MalformedReplyError is returned when proxy reply violates the protocol,
+// it matches both ErrMalformedReply and ErrInvalidProxyResponse
+type MalformedReplyError struct {
+	// Reply holds the reply bytes read up to the offending field
+	Reply []byte
+}
+
+func (e *MalformedReplyError) Error() string {
+	return fmt.Sprintf("%s: % x", ErrMalformedReply, e.Reply)
+}
+
+// Is reports whether target is one of the errors e matches
+func (e *MalformedReplyError) Is(target error) bool {
+	return target == ErrMalformedReply || target == ErrInvalidProxyResponse
+}
+
+func malformed(b []byte) error {
+	return &MalformedReplyError{Reply: append([]byte(nil), b...)}
+}
+
 // DialerOption is a dialer option setter
 type DialerOption func(d *Dialer) error
 
-// DialerAuth is an option to provide auth credentials to dialer
+// DialerAuth is an option to provide auth credentials to dialer. RFC 1929
+// limits both to 255 bytes; the limit is on the encoded length, so a
+// UTF-8 string or a token may hit it well before 255 characters.
 func DialerAuth(user, pass string) DialerOption {
 	return func(d *Dialer) error {
-		d.user = user
-		d.pass = pass
+		if len(user) > maxCredentialLen || len(pass) > maxCredentialLen {
+			return ErrCredentialsTooLong
+		}
+		d.client.Username = user
+		d.client.Password = pass
+		return nil
+	}
+}
+
+// DialerMethods is an option to set authentication methods offered to the
+// proxy in order of preference, see Client.Methods
+func DialerMethods(methods ...AuthMethod) DialerOption {
+	return func(d *Dialer) error {
+		if len(methods) > maxMethods {
+			return ErrTooManyMethods
+		}
+		d.client.Methods = methods
+		return nil
+	}
+}
+
+// DialerRand is an option to set entropy source for isolation credentials,
+// crypto/rand if nil. It fails if placed after DialerTorIsolation, when
+// credentials are already generated.
+func DialerRand(r io.Reader) DialerOption {
+	return func(d *Dialer) error {
+		if d.isolated {
+			return errors.New("isolation credentials already generated")
+		}
+		d.rand = r
+		return nil
+	}
+}
+
+// DialerOnRequest is an option to set request hook, see Client.OnRequest
+func DialerOnRequest(f func(req *Request) error) DialerOption {
+	return func(d *Dialer) error {
+		d.client.OnRequest = f
 		return nil
 	}
 }
@@ -77,35 +201,36 @@ func DialerAuth(user, pass string) DialerOption {
 // DialerTorIsolation is an option to request Tor isolation from dialer
 func DialerTorIsolation() DialerOption {
 	return func(d *Dialer) error {
-		if d.user != "" || d.pass != "" {
+		if d.client.Username != "" || d.client.Password != "" {
 			return errors.New("credentials already set")
 		}
+		r := d.rand
+		if r == nil {
+			r = rand.Reader
+		}
 		var b [16]byte
-		_, err := io.ReadFull(rand.Reader, b[:])
+		_, err := io.ReadFull(r, b[:])
 		if err != nil {
 			return err
 		}
-		d.user = hex.EncodeToString(b[0:8])
-		d.pass = hex.EncodeToString(b[8:16])
+		d.client.Username = hex.EncodeToString(b[0:8])
+		d.client.Password = hex.EncodeToString(b[8:16])
+		d.isolated = true
 		return nil
 	}
 }
 
 // Dialer represents connection to the SOCKS proxy
 type Dialer struct {
-	conn net.Conn
-
-	user         string
-	pass         string
-	torIsolation bool
+	conn     net.Conn
+	client   Client
+	connect  time.Duration // time it took Proxy to connect
+	rand     io.Reader
+	isolated bool // credentials are generated by DialerTorIsolation
+	span     StartSpanFunc
 
 	used bool
 	mux  sync.Mutex
-
-	net  string
-	host string
-	port int
-	err  error
 }
 
 // NewDialer builds SOCKS5 dialer from raw connection to the server
@@ -122,172 +247,110 @@ func NewDialer(conn net.Conn, opts ...DialerOption) (*Dialer, error) {
 
 // Dial returns proxied connection
 func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext returns proxied connection. If ctx has a deadline, it is
+// applied to the handshake I/O and cleared once the handshake is done.
+//
+// TCP networks are connected with CONNECT command and return *ProxiedConn,
+// UDP networks go through UDP association and return *UDPConn. Other
+// networks fail with ErrUnsupportedNetwork.
+//
+// Target names are resolved by the proxy. With networks restricted to an
+// address family, such as "tcp4", IP addresses of the other family are
+// rejected, and a connection is rejected with ReplyAddressTypeNotSupported
+// error if the proxy reports a bound address of the other family; proxies
+// reporting no bound address can't be checked. A name can't be used with
+// "udp4" or "udp6", as the relay resolves it for each datagram. Use
+// Proxy.ResolveLocal to resolve names before dialing instead.
+//
+// Errors other than ErrConnUsed are returned as *net.OpError with Op set
+// to "socks connect" and Addr set to the proxy address; the SOCKS specific
+// error is available as its Err field.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
 	d.mux.Lock()
 	if d.used {
+		d.mux.Unlock()
 		return nil, ErrConnUsed
 	}
 	d.used = true
 	d.mux.Unlock()
 
-	host, strPort, err := net.SplitHostPort(addr)
-	if err != nil {
-		return nil, err
-	}
-	port, err := strconv.Atoi(strPort)
-	if err != nil {
-		return nil, err
-	}
-
-	d.net = network
-	d.host = host
-	d.port = port
-
-	d.connect()
-
-	if d.err != nil {
-		d.conn.Close()
-		return nil, d.err
+	if d.span == nil {
+		return d.dial(ctx, network, addr)
 	}
-
-	return d.conn, nil
+	span := d.span(ctx, network, addr)
+	conn, err := d.dial(ctx, network, addr)
+	endSpan(span, conn, err)
+	return conn, err
 }
 
-func (d *Dialer) connect() {
-	buf := make([]byte, 32+len(d.host)+len(d.user)+len(d.pass))
-
-	// Initial greeting
-	buf[0] = protocolVersion
-	if d.user != "" {
-		buf = buf[:4]
-		buf[1] = 2 // num auth methods
-		buf[2] = authNone
-		buf[3] = authUsernamePassword
-	} else {
-		buf = buf[:3]
-		buf[1] = 1 // num auth methods
-		buf[2] = authNone
-	}
-
-	_, d.err = d.conn.Write(buf)
-	if d.err != nil {
-		return
-	}
-
-	// Server's auth choice
-
-	_, d.err = io.ReadFull(d.conn, buf[:2])
-	if d.err != nil {
-		return
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := splitTarget(addr)
+	if err != nil {
+		return nil, d.fail(network, err)
 	}
-	if buf[0] != protocolVersion {
-		d.err = ErrInvalidProxyResponse
-		return
+	if err := checkFamily(network, addr); err != nil {
+		return nil, d.fail(network, err)
 	}
-
-	switch buf[1] {
-	default:
-		d.err = ErrInvalidProxyResponse
-		return
-	case authUnavailable:
-		d.err = ErrNoAcceptableAuthMethod
-		return
-	case authGssAPI:
-		d.err = ErrNoAcceptableAuthMethod
-		return
-	case authUsernamePassword:
-		buf = buf[:3+len(d.user)+len(d.pass)]
-		buf[0] = 1 // version
-		buf[1] = byte(len(d.user))
-		copy(buf[2:], d.user)
-		buf[2+len(d.user)] = byte(len(d.pass))
-		copy(buf[3+len(d.user):], d.pass)
-
-		_, d.err = d.conn.Write(buf)
-		if d.err != nil {
-			return
+	named := net.ParseIP(host) == nil
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp4", "udp6":
+		if named {
+			// Relay resolves names per datagram, the family can't be
+			// enforced
+			return nil, d.fail(network, statusErrors[statusAddressTypeNotSupported])
 		}
-		_, d.err = io.ReadFull(d.conn, buf[:2])
-		if d.err != nil {
-			return
-		}
-
-		if buf[0] != 1 { // version
-			d.err = ErrInvalidProxyResponse
-			return
-		} else if buf[1] != 0 { // 0 = succes, else auth failed
-			d.err = ErrAuthFailed
-			return
+		fallthrough
+	case "udp":
+		u, err := d.client.associate(ctx, d.conn, addr)
+		if err != nil {
+			return nil, d.fail(network, err)
 		}
-	case authNone:
-		// Do nothing
+		u.mux.Lock()
+		u.info.Timings.Connect = d.connect
+		u.mux.Unlock()
+		return u, nil
+	default:
+		return nil, d.fail(network, ErrUnsupportedNetwork)
 	}
 
-	// Command / connection request
-
-	buf = buf[:7+len(d.host)]
-	buf[0] = protocolVersion
-	buf[1] = commandTCPConnect
-	buf[2] = 0 // reserved
-	buf[3] = addressTypeDomain
-	buf[4] = byte(len(d.host))
-	copy(buf[5:], d.host)
-	buf[5+len(d.host)] = byte(d.port >> 8)
-	buf[6+len(d.host)] = byte(d.port & 0xff)
-
-	_, d.err = d.conn.Write(buf)
-	if d.err != nil {
-		return
+	client := d.client
+	if f := client.OnRequest; f != nil {
+		// Report the target actually requested
+		client.OnRequest = func(req *Request) error {
+			err := f(req)
+			host, port = req.Addr.Name, req.Addr.Port
+			if host == "" {
+				host = req.Addr.IP.String()
+			}
+			return err
+		}
 	}
-
-	// Server response
-
-	_, d.err = io.ReadFull(d.conn, buf[:4])
-	if d.err != nil {
-		return
+	rep, err := client.Handshake(ctx, d.conn, CommandConnect, addr)
+	if err != nil {
+		return nil, d.fail(network, err)
 	}
-
-	if buf[0] != protocolVersion {
-		d.err = ErrInvalidProxyResponse
-		return
+	if ip := rep.BoundAddr.IP; named && ip != nil && !ip.IsUnspecified() && !inFamily(ip, family(network)) {
+		// Proxy resolved the name to the other family
+		return nil, d.fail(network, statusErrors[statusAddressTypeNotSupported])
 	}
 
-	if buf[1] != statusRequestGranted {
-		d.err = statusErrors[buf[1]]
-		if d.err == nil {
-			d.err = ErrInvalidProxyResponse
-		}
-		return
-	}
-
-	switch buf[3] {
-	default:
-		d.err = ErrInvalidProxyResponse
-	case addressTypeIPv4:
-		_, d.err = io.ReadFull(d.conn, buf[:4])
-		if d.err != nil {
-			return
-		}
-	case addressTypeIPv6:
-		_, d.err = io.ReadFull(d.conn, buf[:16])
-		if d.err != nil {
-			return
-		}
-	case addressTypeDomain:
-		_, d.err = io.ReadFull(d.conn, buf[:1])
-		if d.err != nil {
-			return
-		}
-		domLen := buf[0]
-		_, d.err = io.ReadFull(d.conn, buf[:domLen])
-		if d.err != nil {
-			return
-		}
-	}
+	rep.Timings.Connect = d.connect
+	return &ProxiedConn{Conn: d.conn, host: host, port: port, info: rep.info()}, nil
+}
 
-	_, d.err = io.ReadFull(d.conn, buf[:2])
-	if d.err != nil {
-		return
+// fail closes connection to the proxy and wraps err into *net.OpError
+func (d *Dialer) fail(network string, err error) error {
+	d.conn.Close()
+	return &net.OpError{
+		Op:     "socks connect",
+		Net:    network,
+		Source: d.conn.LocalAddr(),
+		Addr:   d.conn.RemoteAddr(),
+		Err:    err,
 	}
-
-	return
 }
@@ -0,0 +1,180 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReplyErrorClassification(t *testing.T) {
+	tests := []struct {
+		code      byte
+		timeout   bool
+		temporary bool
+	}{
+		{statusGeneralFailure, false, true},
+		{statusConnectionNotAllowed, false, false},
+		{statusNetworkUnreachable, false, true},
+		{statusHostUnreachable, false, true},
+		{statusConnectionRefused, false, false},
+		{statusTTLExpired, true, true},
+		{statusCommandNotSupport, false, false},
+		{statusAddressTypeNotSupported, false, false},
+	}
+	for _, tt := range tests {
+		fake := newFakeProxy(t)
+		fake.reply = []byte{protocolVersion, tt.code, 0, addressTypeIPv4, 0, 0, 0, 0, 0, 0}
+		p, err := NewProxy(fake.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.Dial("tcp", "example.com:80")
+
+		var re *ReplyError
+		if !errors.As(err, &re) || re.Code != tt.code {
+			t.Errorf("code %d: got %v", tt.code, err)
+			continue
+		}
+		ne, ok := err.(net.Error)
+		if !ok {
+			t.Errorf("code %d: %T is not net.Error", tt.code, err)
+			continue
+		}
+		if ne.Timeout() != tt.timeout || ne.Temporary() != tt.temporary {
+			t.Errorf("code %d: timeout %v, temporary %v", tt.code, ne.Timeout(), ne.Temporary())
+		}
+	}
+}
+
+func TestCredentialsTooLong(t *testing.T) {
+	long := strings.Repeat("ü", 128) // 128 characters, 256 bytes
+	fake := newFakeProxy(t)
+
+	for _, auth := range [][2]string{{long, "p"}, {"u", long}} {
+		p, err := NewProxyAuth(fake.Addr(), auth[0], auth[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Dial("tcp", "127.0.0.1:80"); !errors.Is(err, ErrCredentialsTooLong) {
+			t.Errorf("Dial: got %v", err)
+		}
+
+		// Nothing is sent to the proxy
+		a, b := net.Pipe()
+		b.Close()
+		c := Client{Username: auth[0], Password: auth[1]}
+		if _, err := c.Handshake(context.Background(), a, CommandConnect, "127.0.0.1:80"); !errors.Is(err, ErrCredentialsTooLong) {
+			t.Errorf("Handshake: got %v", err)
+		}
+		a.Close()
+	}
+
+	max := strings.Repeat("x", 255)
+	fake.user, fake.pass = max, max
+	echo := newEchoServer(t)
+	p, err := NewProxyAuth(fake.Addr(), max, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestDialAddressFamily(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := p.Dial("tcp4", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _ := c.(*ProxiedConn).Target(); host != "localhost" {
+		t.Errorf("requested %q, the name should go to the proxy", host)
+	}
+	c.Close()
+
+	for _, tt := range [][2]string{{"tcp6", "127.0.0.1"}, {"tcp4", "::1"}, {"udp6", "127.0.0.1"}} {
+		_, err := p.Dial(tt[0], net.JoinHostPort(tt[1], port))
+		var ae *net.AddrError
+		if !errors.As(err, &ae) {
+			t.Errorf("%s %s: got %v", tt[0], tt[1], err)
+		}
+	}
+
+	// Family is checked on the bound address. The name resolves nowhere,
+	// so a local lookup would fail.
+	ipv4 := []byte{5, 0, 0, 1, 10, 0, 0, 1, 0, 80}
+	ipv6 := append(append([]byte{5, 0, 0, 4}, net.ParseIP("2001:db8::1")...), 0, 80)
+	unspecified := []byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	tests := []struct {
+		network string
+		reply   []byte
+		ok      bool
+	}{
+		{"tcp4", ipv4, true},
+		{"tcp4", ipv6, false},
+		{"tcp6", ipv6, true},
+		{"tcp6", ipv4, false},
+		{"tcp6", unspecified, true},
+		{"udp4", ipv4, false},
+	}
+	for _, tt := range tests {
+		fake.reply = tt.reply
+		c, err := p.Dial(tt.network, "only-proxy.invalid:80")
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s, bound % x: %v", tt.network, tt.reply[4:], err)
+			} else {
+				c.Close()
+			}
+			continue
+		}
+		var re *ReplyError
+		if !errors.As(err, &re) || re.Code != ReplyAddressTypeNotSupported {
+			t.Errorf("%s, bound % x: got %v", tt.network, tt.reply[4:], err)
+		}
+	}
+}
+
+func TestDialerRand(t *testing.T) {
+	seed := bytes.Repeat([]byte{0xab}, 16)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	d, err := NewDialer(a, DialerRand(bytes.NewReader(seed)), DialerTorIsolation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.client.Username != "abababababababab" || d.client.Password != "abababababababab" {
+		t.Errorf("credentials are %q, %q", d.client.Username, d.client.Password)
+	}
+
+	// Exhausted source fails the dialer
+	if _, err := NewDialer(a, DialerRand(bytes.NewReader(nil)), DialerTorIsolation()); err == nil {
+		t.Error("expected error with empty entropy source")
+	}
+
+	// Source set after credentials are generated would be ignored
+	if _, err := NewDialer(a, DialerTorIsolation(), DialerRand(bytes.NewReader(seed))); err == nil {
+		t.Error("expected error with DialerRand after DialerTorIsolation")
+	}
+	if _, err := NewDialer(a, DialerAuth("u", "p"), DialerRand(bytes.NewReader(seed))); err != nil {
+		t.Errorf("DialerRand after DialerAuth: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// Method range reserved by RFC 1928 for private use
+const (
+	minPrivateMethod = 0x80
+	maxPrivateMethod = 0xfe
+)
+
+// MethodFunc performs subnegotiation of a private authentication method
+// over conn once the peer has selected it
+type MethodFunc func(conn net.Conn) error
+
+// Extensions is a registry of private authentication methods agreed on by
+// matched deployments. Peers not knowing them pick standard methods, so a
+// registry can be shared by all clients. Private commands need no
+// registration, Client.Handshake sends any command as given.
+type Extensions struct {
+	mux     sync.RWMutex
+	methods map[AuthMethod]MethodFunc
+}
+
+// RegisterMethod registers subnegotiation for private method m, which has
+// to be in range 0x80 to 0xfe. Registered methods are offered ahead of
+// standard ones unless the client lists methods explicitly.
+func (e *Extensions) RegisterMethod(m AuthMethod, f MethodFunc) error {
+	if m < minPrivateMethod || m > maxPrivateMethod {
+		return ErrNotPrivate
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.methods == nil {
+		e.methods = make(map[AuthMethod]MethodFunc)
+	}
+	e.methods[m] = f
+	return nil
+}
+
+// Method returns subnegotiation of method m or nil if it isn't registered
+func (e *Extensions) Method(m AuthMethod) MethodFunc {
+	if e == nil {
+		return nil
+	}
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.methods[m]
+}
+
+// Methods returns registered methods in ascending order
+func (e *Extensions) Methods() []AuthMethod {
+	if e == nil {
+		return nil
+	}
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	methods := make([]AuthMethod, 0, len(e.methods))
+	for m := range e.methods {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i] < methods[j] })
+	return methods
+}
+
+// DialerExtensions is an option to negotiate private methods from the
+// registry, see Client.Extensions
+func DialerExtensions(e *Extensions) DialerOption {
+	return func(d *Dialer) error {
+		d.client.Extensions = e
+		return nil
+	}
+}
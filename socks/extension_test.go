@@ -0,0 +1,92 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestExtensionsRegister(t *testing.T) {
+	var e Extensions
+	for _, m := range []AuthMethod{AuthNone, AuthUsernamePassword, 0x7f, 0xff} {
+		if err := e.RegisterMethod(m, nil); !errors.Is(err, ErrNotPrivate) {
+			t.Errorf("method %#x: got %v", m, err)
+		}
+	}
+	for _, m := range []AuthMethod{0xfe, 0x80} {
+		if err := e.RegisterMethod(m, func(net.Conn) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := e.Methods(); len(got) != 2 || got[0] != 0x80 || got[1] != 0xfe {
+		t.Errorf("methods are %v", got)
+	}
+	var nilExt *Extensions
+	if nilExt.Method(0x80) != nil || nilExt.Methods() != nil {
+		t.Error("nil registry has methods")
+	}
+}
+
+func TestHandshakeExtensions(t *testing.T) {
+	hint := []byte("idle=30")
+	tests := []struct {
+		name     string
+		choice   byte
+		greeting []byte
+	}{
+		{"private", 0x80, []byte{5, 2, 0x80, 0}},
+		{"standard peer", authNone, []byte{5, 2, 0x80, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Extensions
+			e.RegisterMethod(0x80, func(conn net.Conn) error {
+				_, err := conn.Write(hint)
+				return err
+			})
+
+			client, server := net.Pipe()
+			defer client.Close()
+			got := make(chan [2][]byte, 1)
+			go func() {
+				defer server.Close()
+				b := make([]byte, 512)
+				io.ReadFull(server, b[:2])
+				io.ReadFull(server, b[2:2+b[1]])
+				greeting := append([]byte(nil), b[:2+b[1]]...)
+				server.Write([]byte{5, tt.choice})
+				var sub []byte
+				if tt.choice == 0x80 {
+					sub = make([]byte, len(hint))
+					io.ReadFull(server, sub)
+				}
+				got <- [2][]byte{greeting, sub}
+				io.ReadFull(server, b[:10])
+				server.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			}()
+
+			c := Client{Extensions: &e}
+			rep, err := c.Handshake(context.Background(), client, CommandConnect, "10.0.0.1:80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Method != AuthMethod(tt.choice) {
+				t.Errorf("method is %v", rep.Method)
+			}
+			g := <-got
+			if !bytes.Equal(g[0], tt.greeting) {
+				t.Errorf("greeting is % x, want % x", g[0], tt.greeting)
+			}
+			if tt.choice == 0x80 && !bytes.Equal(g[1], hint) {
+				t.Errorf("subnegotiation sent %q", g[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProxy is a minimal SOCKS5 server for tests. It serves CONNECT, BIND
+// and UDP ASSOCIATE, requiring username/password authentication if user is set.
+type fakeProxy struct {
+	l    net.Listener
+	user string
+	pass string
+
+	// reply, if set, is written instead of the CONNECT reply and the
+	// connection is closed afterwards
+	reply []byte
+
+	mux   sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeProxy(t testing.TB) *fakeProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProxy{l: l}
+	t.Cleanup(func() { l.Close() })
+	go p.serve()
+	return p
+}
+
+func (p *fakeProxy) Addr() string {
+	return p.l.Addr().String()
+}
+
+func (p *fakeProxy) serve() {
+	for {
+		c, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		p.mux.Lock()
+		p.conns = append(p.conns, c)
+		p.mux.Unlock()
+		go p.handle(c)
+	}
+}
+
+// drop closes all client connections accepted so far
+func (p *fakeProxy) drop() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func (p *fakeProxy) handle(c net.Conn) {
+	defer c.Close()
+
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	want := byte(authNone)
+	if p.user != "" {
+		want = authUsernamePassword
+	}
+	method := byte(authUnavailable)
+	for _, m := range methods {
+		if m == want {
+			method = want
+		}
+	}
+	c.Write([]byte{protocolVersion, method})
+
+	switch method {
+	case authUnavailable:
+		return
+	case authUsernamePassword:
+		if _, err := io.ReadFull(c, buf[:2]); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(c, user)
+		io.ReadFull(c, buf[:1])
+		pass := make([]byte, buf[0])
+		io.ReadFull(c, pass)
+		if string(user) != p.user || string(pass) != p.pass {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	if _, err := io.ReadFull(c, buf[:4]); err != nil {
+		return
+	}
+	command := buf[1]
+	var host string
+	switch buf[3] {
+	case addressTypeIPv4, addressTypeIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if buf[3] == addressTypeIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		io.ReadFull(c, ip)
+		host = ip.String()
+	case addressTypeDomain:
+		io.ReadFull(c, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+
+	if p.reply != nil {
+		c.Write(p.reply)
+		return
+	}
+
+	switch command {
+	case commandTCPConnect:
+		d, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			c.Write([]byte{protocolVersion, statusConnectionRefused, 0, addressTypeIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer d.Close()
+		a := d.LocalAddr().(*net.TCPAddr)
+		c.Write(appendAddr([]byte{protocolVersion, statusRequestGranted, 0}, a.IP.String(), a.Port))
+		go func() {
+			io.Copy(d, c)
+			d.(*net.TCPConn).CloseWrite()
+		}()
+		io.Copy(c, d)
+	case commandTCPBind:
+		p.bind(c)
+	case commandUDPAssociate:
+		p.relay(c)
+	default:
+		c.Write([]byte{protocolVersion, statusCommandNotSupport, 0, addressTypeIPv4, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// bind accepts a single connection for BIND request on control
+// connection c and relays it
+func (p *fakeProxy) bind(c net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer l.Close()
+	a := l.Addr().(*net.TCPAddr)
+	// Like with UDP, the client is expected to substitute the proxy address
+	c.Write(appendAddr([]byte{protocolVersion, statusRequestGranted, 0}, "0.0.0.0", a.Port))
+
+	// Control connection going away cancels the BIND. The watch is
+	// stopped with a deadline once the peer connects, bytes it has read
+	// by then are passed on.
+	early := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 512)
+		n, err := c.Read(b)
+		if err != nil && !os.IsTimeout(err) {
+			l.Close()
+		}
+		early <- b[:n]
+	}()
+	d, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	c.SetReadDeadline(time.Now())
+	d.Write(<-early)
+	c.SetReadDeadline(time.Time{})
+	a = d.RemoteAddr().(*net.TCPAddr)
+	c.Write(appendAddr([]byte{protocolVersion, statusRequestGranted, 0}, a.IP.String(), a.Port))
+	go io.Copy(d, c)
+	io.Copy(c, d)
+}
+
+// relay serves UDP association for the control connection c
+func (p *fakeProxy) relay(c net.Conn) {
+	u, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer u.Close()
+	a := u.LocalAddr().(*net.UDPAddr)
+	// Bound address is unspecified, the client is expected to substitute
+	// the proxy address
+	c.Write(appendAddr([]byte{protocolVersion, statusRequestGranted, 0}, "0.0.0.0", a.Port))
+
+	go func() {
+		var client *net.UDPAddr
+		b := make([]byte, 65535)
+		for {
+			n, from, err := u.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			if client == nil || from.String() == client.String() {
+				addr, off, ok := parseUDPHeader(b[:n])
+				if !ok {
+					continue
+				}
+				client = from
+				dst, err := net.ResolveUDPAddr("udp", addr.String())
+				if err != nil {
+					continue
+				}
+				u.WriteToUDP(b[off:n], dst)
+				continue
+			}
+			h := appendAddr([]byte{0, 0, 0}, from.IP.String(), from.Port)
+			u.WriteToUDP(append(h, b[:n]...), client)
+		}
+	}()
+	io.Copy(ioutil.Discard, c)
+}
+
+// newEchoServer starts TCP server echoing everything back
+func newEchoServer(t testing.TB) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// race dials addr through Addr and Backup endpoints, starting each attempt
+// once the previous one failed or FallbackDelay passed, and returns the
+// first proxied connection established. Connections of the losing
+// attempts are closed. If all attempts fail, error of the primary one is
+// returned.
+func (p *Proxy) race(ctx context.Context, network, addr string, opts ...DialerOption) (net.Conn, error) {
+	endpoints := append([]*net.TCPAddr{p.Addr}, p.Backup...)
+
+	type result struct {
+		i    int
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(endpoints))
+
+	var mux sync.Mutex
+	conns := make([]net.Conn, len(endpoints)) // connections to the proxy
+	done := false
+
+	start := func(i int) {
+		dial := func(ctx context.Context) (net.Conn, error) {
+			c, err := p.obfuscate(p.dialForward(ctx, endpoints[i].String()))
+			if err != nil {
+				return nil, err
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			if done {
+				// Another attempt won meanwhile
+				c.Close()
+				return nil, net.ErrClosed
+			}
+			conns[i] = c
+			return c, nil
+		}
+		go func() {
+			c, err := p.through(ctx, dial, network, addr, opts...)
+			results <- result{i, c, err}
+		}()
+	}
+
+	// abort closes connections of all attempts but the winner, if any, and
+	// disposes of the results still to come
+	abort := func(winner, pending int) {
+		mux.Lock()
+		done = true
+		for i, c := range conns {
+			if c != nil && i != winner {
+				c.Close()
+			}
+		}
+		mux.Unlock()
+		go func() {
+			for ; pending > 0; pending-- {
+				if r := <-results; r.conn != nil {
+					r.conn.Close()
+				}
+			}
+		}()
+	}
+
+	next, pending := 1, 1
+	start(0)
+	timer := time.NewTimer(p.FallbackDelay)
+	defer func() { timer.Stop() }()
+	var primary error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				abort(r.i, pending)
+				return r.conn, nil
+			}
+			if r.i == 0 {
+				primary = r.err
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			abort(-1, pending)
+			return nil, ctx.Err()
+		}
+		if next < len(endpoints) {
+			start(next)
+			next++
+			pending++
+			timer.Stop()
+			timer = time.NewTimer(p.FallbackDelay)
+		}
+	}
+	return nil, primary
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newStuckProxy starts server accepting connections and never answering,
+// closed connections are reported on the returned channel
+func newStuckProxy(t *testing.T) (net.Listener, chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	closed := make(chan struct{}, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(ioutil.Discard, c)
+				c.Close()
+				closed <- struct{}{}
+			}()
+		}
+	}()
+	return l, closed
+}
+
+func TestProxyFallbackDelay(t *testing.T) {
+	echo := newEchoServer(t)
+	stuck, closed := newStuckProxy(t)
+	fake := newFakeProxy(t)
+
+	p, err := NewProxy(stuck.Addr().String() + "," + fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.FallbackDelay = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	c, err := p.DialContext(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("dial took %v", d)
+	}
+	if got := c.(*ProxiedConn).Proxy().String(); got != fake.Addr() {
+		t.Errorf("connected through %v", got)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("losing attempt wasn't closed")
+	}
+}
+
+func TestProxyFallbackPrimaryWins(t *testing.T) {
+	echo := newEchoServer(t)
+	primary := newFakeProxy(t)
+	backup := newFakeProxy(t)
+
+	p, err := NewProxy(primary.Addr() + "," + backup.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.FallbackDelay = time.Second
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	backup.mux.Lock()
+	defer backup.mux.Unlock()
+	if len(backup.conns) != 0 {
+		t.Error("backup was dialed")
+	}
+}
+
+func TestProxyFallbackAllFail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+	fake := newFakeProxy(t)
+	fake.reply = []byte{5, ReplyConnectionRefused, 0, 1, 0, 0, 0, 0, 0, 0}
+
+	p, err := NewProxy(dead + "," + fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.FallbackDelay = time.Second
+	start := time.Now()
+	_, err = p.Dial("tcp", "127.0.0.1:1")
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("got %v, want error of the primary", err)
+	}
+	// Failed attempt starts the next one without waiting for the delay
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("dial took %v", d)
+	}
+}
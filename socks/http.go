@@ -0,0 +1,109 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPOption is an option for the client built by Proxy.HTTPClient
+type HTTPOption func(c *http.Client, t *http.Transport)
+
+// HTTPTimeout is an option to limit the total time of a request, including
+// reading the response body
+func HTTPTimeout(d time.Duration) HTTPOption {
+	return func(c *http.Client, t *http.Transport) {
+		c.Timeout = d
+	}
+}
+
+// HTTPTLSHandshakeTimeout is an option to limit the time spent on TLS
+// handshake with the target
+func HTTPTLSHandshakeTimeout(d time.Duration) HTTPOption {
+	return func(c *http.Client, t *http.Transport) {
+		t.TLSHandshakeTimeout = d
+	}
+}
+
+// HTTPRootCAs is an option to verify target certificates against pool
+// instead of the system roots
+func HTTPRootCAs(pool *x509.CertPool) HTTPOption {
+	return func(c *http.Client, t *http.Transport) {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+}
+
+// HTTPDisableHTTP2 is an option to speak only HTTP/1.1 to targets, by
+// default HTTP/2 is negotiated with ALPN where targets support it
+func HTTPDisableHTTP2() HTTPOption {
+	return func(c *http.Client, t *http.Transport) {
+		t.ForceAttemptHTTP2 = false
+	}
+}
+
+// HTTPClient returns HTTP client sending all requests through the proxy.
+// Its transport keeps idle proxied connections for reuse and passes
+// request contexts down to the proxy dial; other defaults match
+// http.DefaultTransport. TLS to the target is negotiated over the tunnel
+// and verified against the requested host name.
+func (p *Proxy) HTTPClient(opts ...HTTPOption) *http.Client {
+	t := &http.Transport{
+		DialContext:           p.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return p.dialHTTPS(ctx, t, network, addr)
+	}
+	c := &http.Client{Transport: t}
+	for _, opt := range opts {
+		opt(c, t)
+	}
+	return c
+}
+
+// dialHTTPS dials TLS connection for transport t, which doesn't apply its
+// TLS settings to connections from DialTLSContext by itself. The transport
+// only uses HTTP/2 over such connections when ForceAttemptHTTP2 is set and
+// "h2" was negotiated, so it's offered unless NextProtos say otherwise.
+func (p *Proxy) dialHTTPS(ctx context.Context, t *http.Transport, network, addr string) (net.Conn, error) {
+	c, err := p.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	config := t.TLSClientConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if len(config.NextProtos) == 0 {
+		config = config.Clone()
+		config.NextProtos = []string{"http/1.1"}
+		if t.ForceAttemptHTTP2 {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+	if t.TLSHandshakeTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	tc, err := tlsClient(ctx, c, addr, config)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// newTLSServer starts HTTPS server recording server names sent by clients.
+// Its certificate is valid for example.com and 127.0.0.1.
+func newTLSServer(t *testing.T) (*httptest.Server, func() []string) {
+	var (
+		mux   sync.Mutex
+		names []string
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			mux.Lock()
+			names = append(names, h.ServerName)
+			mux.Unlock()
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mux.Lock()
+		defer mux.Unlock()
+		return append([]string(nil), names...)
+	}
+}
+
+func TestDialTLSContext(t *testing.T) {
+	srv, names := newTLSServer(t)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	ctx := context.Background()
+
+	// The target is verified by the name it's dialed with, which the
+	// certificate doesn't cover
+	_, err = p.DialTLSContext(ctx, "tcp", net.JoinHostPort("localhost", port), &tls.Config{RootCAs: roots})
+	if err == nil {
+		t.Fatal("certificate accepted for localhost")
+	}
+
+	c, err := p.DialTLSContext(ctx, "tcp", net.JoinHostPort("localhost", port), &tls.Config{RootCAs: roots, ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if got := names(); len(got) != 2 || got[0] != "localhost" || got[1] != "example.com" {
+		t.Errorf("server names are %q", got)
+	}
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	srv, _ := newTLSServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.HTTPClient().Get(srv.URL); err == nil {
+		t.Error("untrusted certificate accepted")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	resp, err := p.HTTPClient(HTTPRootCAs(roots)).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil {
+		t.Error("response not over TLS")
+	}
+}
+
+func TestHTTPClientHTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  []HTTPOption
+		proto int
+	}{
+		{"default", nil, 2},
+		{"disabled", []HTTPOption{HTTPDisableHTTP2()}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := p.HTTPClient(append(tt.opts, HTTPRootCAs(roots))...).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != tt.proto {
+				t.Errorf("got %s, want HTTP/%d", resp.Proto, tt.proto)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Listen returns listener accepting connections made to the proxy, so a
+// service can be reached at the proxy's public address. It's built on BIND
+// requests; the addr is the address connections are expected from and
+// "0.0.0.0:0" is commonly accepted as any.
+//
+// A BIND request accepts a single connection, so the listener issues a new
+// one after every accepted connection. Proxies usually bind a new port for
+// each request, in which case Addr changes after every Accept and has to
+// be published again. Target of accepted connections reports the peer.
+func (p *Proxy) Listen(ctx context.Context, network, addr string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "socks bind", Net: network, Err: ErrUnsupportedNetwork}
+	}
+	if _, _, err := splitTarget(addr); err != nil {
+		return nil, &net.OpError{Op: "socks bind", Net: network, Err: err}
+	}
+	l := &bindListener{p: p, network: network, addr: addr, conns: make(map[net.Conn]struct{})}
+	b, err := l.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.pending = b
+	l.bound = b.bound
+	return l, nil
+}
+
+type bindListener struct {
+	p       *Proxy
+	network string
+	addr    string
+
+	mux     sync.Mutex
+	pending *pendingBind
+	bound   *Addr
+	conns   map[net.Conn]struct{} // control connections of BIND requests in flight
+	closed  bool
+}
+
+// pendingBind is a BIND request waiting for a connection
+type pendingBind struct {
+	conn   net.Conn
+	method AuthMethod
+	bound  *Addr
+}
+
+// bind issues BIND request over a new connection to the proxy
+func (l *bindListener) bind(ctx context.Context) (*pendingBind, error) {
+	c, err := l.p.dialProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !l.track(c) {
+		c.Close()
+		return nil, l.fail(c, net.ErrClosed)
+	}
+	d, err := l.p.Dialer(c)
+	if err != nil {
+		l.untrack(c)
+		c.Close()
+		return nil, err
+	}
+	rep, err := d.client.Handshake(ctx, c, CommandBind, l.addr)
+	if err != nil {
+		l.untrack(c)
+		c.Close()
+		return nil, l.fail(c, err)
+	}
+
+	bound := rep.BoundAddr
+	if bound.IP != nil && bound.IP.IsUnspecified() {
+		// Proxy listens on all interfaces, use the one we're talking to
+		if a, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+			bound.IP = a.IP
+		}
+	}
+	return &pendingBind{conn: c, method: rep.Method, bound: &bound}, nil
+}
+
+// Accept waits for a connection to the pending BIND request and issues
+// the next one
+func (l *bindListener) Accept() (net.Conn, error) {
+	l.mux.Lock()
+	b := l.pending
+	l.pending = nil
+	l.mux.Unlock()
+
+	var err error
+	if b == nil {
+		b, err = l.bind(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rep, err := ReadReply(b.conn)
+	l.untrack(b.conn)
+	if err != nil {
+		b.conn.Close()
+		if l.isClosed() {
+			err = net.ErrClosed
+		}
+		return nil, l.fail(b.conn, err)
+	}
+
+	// Next request is issued right away, so Addr reports where the next
+	// connection is accepted. Its failure is reported by the next Accept.
+	next, err := l.bind(context.Background())
+	if err == nil {
+		l.mux.Lock()
+		if l.pending == nil {
+			l.pending = next
+			l.bound = next.bound
+		} else {
+			delete(l.conns, next.conn)
+			next.conn.Close()
+		}
+		l.mux.Unlock()
+	}
+
+	peer := rep.BoundAddr
+	host := peer.Name
+	if host == "" {
+		host = peer.IP.String()
+	}
+	return &ProxiedConn{
+		Conn: b.conn,
+		host: host,
+		port: peer.Port,
+		info: HandshakeInfo{Method: b.method, BoundAddr: *b.bound},
+	}, nil
+}
+
+// Close cancels BIND requests in flight, connections accepted already are
+// left open
+func (l *bindListener) Close() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	for c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+	return nil
+}
+
+// Addr returns proxy address the next connection is accepted at
+func (l *bindListener) Addr() net.Addr {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.bound
+}
+
+func (l *bindListener) track(c net.Conn) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[c] = struct{}{}
+	return true
+}
+
+func (l *bindListener) untrack(c net.Conn) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.conns, c)
+}
+
+func (l *bindListener) isClosed() bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.closed
+}
+
+func (l *bindListener) fail(c net.Conn, err error) error {
+	return &net.OpError{
+		Op:     "socks bind",
+		Net:    l.network,
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    err,
+	}
+}
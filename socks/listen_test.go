@@ -0,0 +1,95 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := p.Listen(context.Background(), "tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// More connections than a single BIND can take
+	for i := 0; i < 3; i++ {
+		addr := l.Addr().String()
+		if l.Addr().(*Addr).IP.IsUnspecified() {
+			t.Fatalf("unspecified address %v", addr)
+		}
+		peer, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		host, port := c.(*ProxiedConn).Target()
+		if net.JoinHostPort(host, itoa(port)) != peer.LocalAddr().String() {
+			t.Errorf("peer is %v:%v, want %v", host, port, peer.LocalAddr())
+		}
+
+		peer.Write([]byte("ping"))
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+			t.Errorf("read %q, %v", b, err)
+		}
+		c.Write([]byte("pong"))
+		if _, err := io.ReadFull(peer, b); err != nil || string(b) != "pong" {
+			t.Errorf("peer read %q, %v", b, err)
+		}
+		if l.Addr().String() == addr {
+			t.Errorf("address %v not rebound", addr)
+		}
+	}
+}
+
+func TestListenClose(t *testing.T) {
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := p.Listen(context.Background(), "tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	l.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept not unblocked by Close")
+	}
+
+	if _, err := p.Listen(context.Background(), "udp", "0.0.0.0:0"); !errors.Is(err, ErrUnsupportedNetwork) {
+		t.Errorf("udp: got %v", err)
+	}
+}
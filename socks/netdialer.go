@@ -0,0 +1,59 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// NetDialer dials through SOCKS5 proxy and is configured like net.Dialer.
+// The net.Dialer fields apply to the connection to the proxy, Timeout and
+// Deadline also bound the handshake.
+type NetDialer struct {
+	Timeout   time.Duration
+	Deadline  time.Time
+	LocalAddr net.Addr
+	KeepAlive time.Duration
+	Resolver  *net.Resolver
+	Control   func(network, address string, c syscall.RawConn) error
+
+	// ProxyAddress is the proxy host:port, port 1080 is assumed if omitted
+	ProxyAddress string
+	// Auth holds credentials, nil if the proxy requires none
+	Auth *Auth
+}
+
+// Dial returns proxied connection
+func (d *NetDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext returns proxied connection
+func (d *NetDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	if !d.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d.Deadline)
+		defer cancel()
+	}
+	fwd := &net.Dialer{
+		LocalAddr: d.LocalAddr,
+		KeepAlive: d.KeepAlive,
+		Resolver:  d.Resolver,
+		Control:   d.Control,
+	}
+	p, err := SOCKS5("tcp", d.ProxyAddress, d.Auth, fwd)
+	if err != nil {
+		return nil, err
+	}
+	return p.DialContext(ctx, network, addr)
+}
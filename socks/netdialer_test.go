@@ -0,0 +1,58 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetDialer(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "u", "p"
+
+	d := &NetDialer{
+		Timeout:      5 * time.Second,
+		ProxyAddress: fake.Addr(),
+		Auth:         &Auth{User: "u", Password: "p"},
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestNetDialerTimeout(t *testing.T) {
+	// Proxy that accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	d := &NetDialer{Timeout: 100 * time.Millisecond, ProxyAddress: l.Addr().String()}
+	start := time.Now()
+	_, err = d.Dial("tcp", "example.com:80")
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("timeout took %v", time.Since(start))
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import "net"
+
+// Obfuscator transforms traffic between the client and the proxy, such as
+// scrambling or padding it, leaving the SOCKS exchange itself unchanged.
+// The proxy side has to undo the transformation, usually with a matching
+// wrapper around its listener.
+type Obfuscator interface {
+	// Wrap returns connection transforming traffic sent over c
+	Wrap(c net.Conn) (net.Conn, error)
+}
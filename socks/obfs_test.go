@@ -0,0 +1,98 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// xorConn flips every byte sent and received with key
+type xorConn struct {
+	net.Conn
+	key byte
+}
+
+func (c *xorConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	for i := range b[:n] {
+		b[i] ^= c.key
+	}
+	return n, err
+}
+
+func (c *xorConn) Write(b []byte) (int, error) {
+	x := make([]byte, len(b))
+	for i := range b {
+		x[i] = b[i] ^ c.key
+	}
+	return c.Conn.Write(x)
+}
+
+type xorObfuscator byte
+
+func (o xorObfuscator) Wrap(c net.Conn) (net.Conn, error) {
+	return &xorConn{Conn: c, key: byte(o)}, nil
+}
+
+// newXORFront starts listener undoing xorObfuscator in front of the proxy
+func newXORFront(t *testing.T, key byte, backend string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b, err := net.Dial("tcp", backend)
+				if err != nil {
+					return
+				}
+				defer b.Close()
+				go io.Copy(b, &xorConn{Conn: c, key: key})
+				io.Copy(&xorConn{Conn: c, key: key}, b)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestObfuscator(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(newXORFront(t, 0x5a, fake.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Obfuscator = xorObfuscator(0x5a)
+
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	// The proxy waits for more of the garbled greeting
+	p.Obfuscator = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.DialContext(ctx, "tcp", echo.Addr().String()); err == nil {
+		t.Error("handshake succeeded without obfuscation")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestNewProxyList(t *testing.T) {
+	p, err := NewProxy("127.0.0.1:1080, 127.0.0.2:1081,127.0.0.3:1082")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Addr.String() != "127.0.0.1:1080" {
+		t.Errorf("primary is %v", p.Addr)
+	}
+	if len(p.Backup) != 2 || p.Backup[0].String() != "127.0.0.2:1081" || p.Backup[1].String() != "127.0.0.3:1082" {
+		t.Errorf("backup is %v", p.Backup)
+	}
+
+	for _, addr := range []string{"1.2.3.4:1080,", ",1.2.3.4:1080", "1.2.3.4:1080, ,1.2.3.5:1080"} {
+		if _, err := NewProxy(addr); err == nil {
+			t.Errorf("%q: expected error", addr)
+		}
+	}
+	if _, err := NewProxyList(nil); err == nil {
+		t.Error("empty list: expected error")
+	}
+}
+
+func TestProxyFailover(t *testing.T) {
+	// Grab a free port and release it so nothing listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+
+	p, err := NewProxy(dead + "," + fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Errorf("read %q", b)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := []struct {
+		addr, want string
+	}{
+		{"127.0.0.1", "127.0.0.1:1080"},
+		{"127.0.0.1:99", "127.0.0.1:99"},
+		{"localhost", "localhost:1080"},
+		{"[::1]", "[::1]:1080"},
+		{"::1", "[::1]:1080"},
+		{"[::1]:5", "[::1]:5"},
+		{"", ""},
+		{":1080", ""},
+		{"1.2.3.4:", ""},
+		{"[]", ""},
+	}
+	for _, tt := range tests {
+		got, err := withDefaultPort(tt.addr)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tt.addr, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: got %q, %v, want %q", tt.addr, got, err, tt.want)
+		}
+	}
+}
+
+func TestDialContextFunc(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := p.DialContextFunc()
+	c, err := dial(context.Background(), "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dial(ctx, "tcp", echo.Addr().String()); err == nil {
+		t.Error("expected error with canceled context")
+	}
+}
+
+func TestProxyAlternates(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "new", "secret"
+
+	tests := []struct {
+		name       string
+		user, pass string
+		alternates []Credentials
+		conns      int
+		err        error
+	}{
+		{"rotated", "old", "secret", []Credentials{{"older", "x"}, {"new", "secret"}}, 3, nil},
+		{"no credentials", "", "", []Credentials{{"new", "secret"}}, 2, nil},
+		{"exhausted", "old", "secret", []Credentials{{"older", "x"}}, 2, ErrAuthFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.drop()
+			p, err := NewProxyAuth(fake.Addr(), tt.user, tt.pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Alternates = tt.alternates
+			c, err := p.Dial("tcp", echo.Addr().String())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if c != nil {
+				c.Close()
+			}
+			fake.mux.Lock()
+			defer fake.mux.Unlock()
+			if len(fake.conns) != tt.conns {
+				t.Errorf("%d connections to the proxy", len(fake.conns))
+			}
+		})
+	}
+}
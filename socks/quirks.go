@@ -0,0 +1,34 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+// Quirks is a set of known protocol deviations the client tolerates when
+// talking to non-conformant proxies. The zero value is strict.
+type Quirks uint
+
+// Known quirks
+const (
+	// QuirkAuthReplyVersion accepts any version byte in the
+	// username/password subnegotiation reply, some proxies send 0 or 5
+	// instead of 1
+	QuirkAuthReplyVersion Quirks = 1 << iota
+
+	// QuirkReplyReserved accepts non-zero reserved byte in the command
+	// reply
+	QuirkReplyReserved
+)
+
+// DialerLenient is an option to tolerate the given protocol deviations
+func DialerLenient(q Quirks) DialerOption {
+	return func(d *Dialer) error {
+		d.client.Quirks = q
+		return nil
+	}
+}
+
+// has reports whether all of quirks in x are enabled
+func (q Quirks) has(x Quirks) bool {
+	return q&x == x
+}
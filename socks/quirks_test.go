@@ -0,0 +1,67 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// sloppyProxy answers a username/password handshake with the given
+// subnegotiation and command replies
+func sloppyProxy(conn net.Conn, authReply, reply []byte) {
+	defer conn.Close()
+	b := make([]byte, 512)
+	io.ReadFull(conn, b[:2])
+	io.ReadFull(conn, b[:b[1]])
+	conn.Write([]byte{5, authUsernamePassword})
+	io.ReadFull(conn, b[:2])
+	io.ReadFull(conn, b[:b[1]])
+	io.ReadFull(conn, b[:1])
+	io.ReadFull(conn, b[:b[0]])
+	conn.Write(authReply)
+	io.ReadFull(conn, b[:10])
+	conn.Write(reply)
+}
+
+func TestQuirks(t *testing.T) {
+	okReply := []byte{5, 0, 0, 1, 10, 0, 0, 1, 0, 80}
+	rsvReply := []byte{5, 0, 0xff, 1, 10, 0, 0, 1, 0, 80}
+	tests := []struct {
+		name      string
+		quirks    Quirks
+		authReply []byte
+		reply     []byte
+		strictErr bool
+	}{
+		{"auth version zero", QuirkAuthReplyVersion, []byte{0, 0}, okReply, true},
+		{"auth version five", QuirkAuthReplyVersion, []byte{5, 0}, okReply, true},
+		{"reserved byte", QuirkReplyReserved, []byte{1, 0}, rsvReply, true},
+		{"conformant", 0, []byte{1, 0}, okReply, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, q := range []Quirks{0, tt.quirks} {
+				client, server := net.Pipe()
+				go sloppyProxy(server, tt.authReply, tt.reply)
+				c := Client{Username: "u", Password: "p", Quirks: q}
+				_, err := c.Handshake(context.Background(), client, CommandConnect, "10.0.0.1:80")
+				client.Close()
+
+				strict := q == 0
+				if strict && tt.strictErr {
+					if !errors.Is(err, ErrMalformedReply) {
+						t.Errorf("strict: expected malformed reply, got %v", err)
+					}
+				} else if err != nil {
+					t.Errorf("quirks %b: %v", q, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const relayBufferSize = 32 * 1024
+
+var relayBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, relayBufferSize)
+		return &b
+	},
+}
+
+// RelayOption is an option of Relay
+type RelayOption func(r *relay)
+
+// RelayIdleTimeout is an option to end the relay once no data has moved in
+// either direction for d
+func RelayIdleTimeout(d time.Duration) RelayOption {
+	return func(r *relay) {
+		r.idle = d
+	}
+}
+
+// Relay copies data between a and b in both directions until both are
+// done, one fails or ctx is done, and closes both connections before
+// returning. End of data from one side is passed on as half-close where
+// the other side supports it, otherwise both are closed. It returns the
+// number of bytes copied from a to b and from b to a, and the first error,
+// which is a timeout net.Error if the idle timeout runs out.
+func Relay(ctx context.Context, a, b net.Conn, opts ...RelayOption) (int64, int64, error) {
+	r := &relay{a: a, b: b}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.touch()
+
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.fail(ctx.Err())
+		case <-stop:
+		}
+	}()
+
+	var ab, ba int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		ab = r.pipe(b, a)
+	}()
+	go func() {
+		defer wg.Done()
+		ba = r.pipe(a, b)
+	}()
+	wg.Wait()
+	close(stop)
+
+	r.fail(nil)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return ab, ba, r.err
+}
+
+type relay struct {
+	a, b net.Conn
+	idle time.Duration
+	last int64 // time of the last transfer in nanoseconds, accessed atomically
+
+	mux    sync.Mutex
+	closed bool
+	err    error
+}
+
+// pipe copies src to dst and passes end of data on
+func (r *relay) pipe(dst, src net.Conn) int64 {
+	n, err := r.copy(dst, src)
+	if err != nil {
+		r.fail(err)
+		return n
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return n
+		}
+	}
+	r.fail(nil)
+	return n
+}
+
+func (r *relay) copy(dst, src net.Conn) (int64, error) {
+	bp := relayBuffers.Get().(*[]byte)
+	defer relayBuffers.Put(bp)
+	buf := *bp
+
+	if r.idle == 0 {
+		return io.CopyBuffer(dst, src, buf)
+	}
+
+	var n int64
+	for {
+		src.SetReadDeadline(time.Now().Add(r.idle))
+		nr, err := src.Read(buf)
+		if nr > 0 {
+			r.touch()
+			nw, err := dst.Write(buf[:nr])
+			n += int64(nw)
+			if err != nil {
+				return n, err
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			// Data moving the other way keeps the relay alive
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && r.sinceLast() < r.idle {
+				continue
+			}
+			return n, err
+		}
+	}
+}
+
+func (r *relay) touch() {
+	atomic.StoreInt64(&r.last, time.Now().UnixNano())
+}
+
+func (r *relay) sinceLast() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&r.last))
+}
+
+// fail closes both connections, recording err unless they're closed
+// already, so errors caused by the closing itself aren't reported
+func (r *relay) fail(err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.err = err
+	r.a.Close()
+	r.b.Close()
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t testing.TB) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+type relayResult struct {
+	ab, ba int64
+	err    error
+}
+
+func startRelay(ctx context.Context, a, b net.Conn, opts ...RelayOption) chan relayResult {
+	done := make(chan relayResult, 1)
+	go func() {
+		ab, ba, err := Relay(ctx, a, b, opts...)
+		done <- relayResult{ab, ba, err}
+	}()
+	return done
+}
+
+func TestRelay(t *testing.T) {
+	for _, idle := range []time.Duration{0, time.Second} {
+		client, a := tcpPair(t)
+		b, err := net.Dial("tcp", newEchoServer(t).Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := startRelay(context.Background(), a, b, RelayIdleTimeout(idle))
+
+		client.Write([]byte("ping!"))
+		client.(*net.TCPConn).CloseWrite()
+		got, err := ioutil.ReadAll(client)
+		if err != nil || string(got) != "ping!" {
+			t.Errorf("idle %v: read %q, %v", idle, got, err)
+		}
+		client.Close()
+
+		r := <-done
+		if r.ab != 5 || r.ba != 5 || r.err != nil {
+			t.Errorf("idle %v: got %+v", idle, r)
+		}
+	}
+}
+
+func TestRelayContext(t *testing.T) {
+	client, a := tcpPair(t)
+	defer client.Close()
+	b, _ := tcpPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := startRelay(ctx, a, b)
+	cancel()
+
+	select {
+	case r := <-done:
+		if !errors.Is(r.err, context.Canceled) {
+			t.Errorf("got %v", r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay not stopped")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("client read %v, want EOF", err)
+	}
+}
+
+func TestRelayIdleTimeout(t *testing.T) {
+	client, a := tcpPair(t)
+	defer client.Close()
+	peer, b := tcpPair(t)
+	defer peer.Close()
+	done := startRelay(context.Background(), a, b, RelayIdleTimeout(100*time.Millisecond))
+
+	// Traffic in one direction keeps the other from timing out
+	for i := 0; i < 4; i++ {
+		client.Write([]byte("x"))
+		io.ReadFull(peer, make([]byte, 1))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case r := <-done:
+		var ne net.Error
+		if !errors.As(r.err, &ne) || !ne.Timeout() {
+			t.Errorf("got %v", r.err)
+		}
+		if r.ab != 4 {
+			t.Errorf("copied %d bytes", r.ab)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay not timed out")
+	}
+}
+
+func TestRelayCloseWrite(t *testing.T) {
+	// net.Pipe can't half-close, so both sides are closed once one ends
+	client, a := tcpPair(t)
+	defer client.Close()
+	b, peer := net.Pipe()
+	defer peer.Close()
+	done := startRelay(context.Background(), a, b)
+	client.(*net.TCPConn).CloseWrite()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Errorf("got %v", r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay not finished")
+	}
+}
+
+func BenchmarkRelay(b *testing.B) {
+	const size = 1 << 20
+	data := make([]byte, size)
+	b.SetBytes(size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		client, x := tcpPair(b)
+		y, peer := tcpPair(b)
+		done := startRelay(context.Background(), x, y, RelayIdleTimeout(time.Minute))
+		go func() {
+			client.Write(data)
+			client.Close()
+		}()
+		io.Copy(ioutil.Discard, peer)
+		peer.Close()
+		<-done
+	}
+}
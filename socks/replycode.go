@@ -0,0 +1,61 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ReplyCodeForError returns the reply code a proxy should send when
+// reaching the destination failed with err, such as an error from
+// net.Dial. Errors of an upstream proxy keep their reply code.
+func ReplyCodeForError(err error) byte {
+	if err == nil {
+		return ReplySucceeded
+	}
+
+	var re *ReplyError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ECONNREFUSED:
+			return ReplyConnectionRefused
+		case syscall.ENETUNREACH:
+			return ReplyNetworkUnreachable
+		case syscall.EHOSTUNREACH:
+			return ReplyHostUnreachable
+		case syscall.EACCES, syscall.EPERM:
+			return ReplyConnectionNotAllowed
+		case syscall.EAFNOSUPPORT:
+			return ReplyAddressTypeNotSupported
+		}
+	}
+
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		// Checked before timeouts, a name that failed to resolve in time
+		// is still unreachable
+		return ReplyHostUnreachable
+	case isTimeout(err):
+		return ReplyTTLExpired
+	case errors.Is(err, ErrUnsupportedNetwork):
+		return ReplyCommandNotSupported
+	case errors.Is(err, ErrHostTooLong):
+		return ReplyAddressTypeNotSupported
+	}
+	return ReplyGeneralFailure
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReplyCodeForError(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: &os.SyscallError{Syscall: "connect", Err: err}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		code byte
+	}{
+		{"nil", nil, ReplySucceeded},
+		{"refused", opErr(syscall.ECONNREFUSED), ReplyConnectionRefused},
+		{"network unreachable", opErr(syscall.ENETUNREACH), ReplyNetworkUnreachable},
+		{"host unreachable", opErr(syscall.EHOSTUNREACH), ReplyHostUnreachable},
+		{"not permitted", opErr(syscall.EPERM), ReplyConnectionNotAllowed},
+		{"address family", opErr(syscall.EAFNOSUPPORT), ReplyAddressTypeNotSupported},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x.invalid", IsNotFound: true}}, ReplyHostUnreachable},
+		{"dns timeout", &net.DNSError{Err: "timeout", Name: "x.invalid", IsTimeout: true}, ReplyHostUnreachable},
+		{"timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, ReplyTTLExpired},
+		{"context deadline", fmt.Errorf("dial: %w", context.DeadlineExceeded), ReplyTTLExpired},
+		{"upstream", &net.OpError{Op: "socks connect", Err: statusErrors[statusConnectionNotAllowed]}, ReplyConnectionNotAllowed},
+		{"unsupported network", ErrUnsupportedNetwork, ReplyCommandNotSupported},
+		{"other", errors.New("boom"), ReplyGeneralFailure},
+	}
+	for _, tt := range tests {
+		if got := ReplyCodeForError(tt.err); got != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.code)
+		}
+	}
+}
+
+func TestReplyCodeForDialError(t *testing.T) {
+	// Grab a free port and release it so nothing listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	if got := ReplyCodeForError(err); got != ReplyConnectionRefused {
+		t.Errorf("%v: got %d", err, got)
+	}
+}
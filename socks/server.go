@@ -0,0 +1,353 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerOption is an option of Server
+type ServerOption func(s *Server)
+
+// ServerDialer is an option to set how destinations are dialed, net.Dialer
+// is used by default. Errors are reported to clients with
+// ReplyCodeForError.
+func ServerDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) ServerOption {
+	return func(s *Server) {
+		s.dial = dial
+	}
+}
+
+// ServerAuth is an option to require username/password authentication,
+// credentials are accepted if check returns true
+func ServerAuth(check func(user, pass string) bool) ServerOption {
+	return func(s *Server) {
+		s.auth = check
+	}
+}
+
+// ServerHandshakeTimeout is an option to limit time from accepting a
+// connection to the reply, including dialing the destination
+func ServerHandshakeTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.timeout = d
+	}
+}
+
+// ServerIdleTimeout is an option to close sessions once no data has moved
+// for d, see RelayIdleTimeout
+func ServerIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.idle = d
+	}
+}
+
+// Server is a SOCKS5 server meant to be embedded into applications. It
+// serves CONNECT requests, other commands are refused. A Server has no
+// global state, any number of them may run in a process.
+type Server struct {
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	auth    func(user, pass string) bool
+	timeout time.Duration
+	idle    time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mux       sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// NewServer returns server with options applied
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		dial:      (&net.Dialer{}).DialContext,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts connections on l and serves each in its own goroutine
+// until accepting fails permanently; temporary failures are retried with
+// backoff. The listener is closed on return. After Close it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.listeners, l)
+		s.mux.Unlock()
+	}()
+
+	var delay time.Duration // how long to sleep on temporary accept failure
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// Such as running out of file descriptors, retry the way
+				// net/http does
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if delay > time.Second {
+					delay = time.Second
+				}
+				t := time.NewTimer(delay)
+				select {
+				case <-t.C:
+				case <-s.ctx.Done():
+					t.Stop()
+					return ErrServerClosed
+				}
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves a single client connection and closes it once the
+// session is over. It returns the error that ended the handshake or the
+// session, if any.
+func (s *Server) ServeConn(c net.Conn) error {
+	defer c.Close()
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return ErrServerClosed
+	}
+	s.conns[c] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.conns, c)
+		s.mux.Unlock()
+	}()
+
+	ctx := s.ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		c.SetDeadline(deadline)
+	}
+
+	dst, err := s.handshake(ctx, c)
+	if err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	var opts []RelayOption
+	if s.idle > 0 {
+		opts = append(opts, RelayIdleTimeout(s.idle))
+	}
+	_, _, err = Relay(s.ctx, c, dst, opts...)
+	return err
+}
+
+// Close stops all listeners and closes all connections being served
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.closed = true
+	s.cancel()
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.closed
+}
+
+// handshake authenticates the client, serves its request and returns
+// connection to the destination
+func (s *Server) handshake(ctx context.Context, c net.Conn) (net.Conn, error) {
+	buf := make([]byte, 2+maxMethods)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != protocolVersion {
+		return nil, ErrMalformedRequest
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, err
+	}
+
+	want := byte(authNone)
+	if s.auth != nil {
+		want = authUsernamePassword
+	}
+	method := byte(authUnavailable)
+	for _, m := range methods {
+		if m == want {
+			method = want
+		}
+	}
+	if _, err := c.Write([]byte{protocolVersion, method}); err != nil {
+		return nil, err
+	}
+	switch method {
+	case authUnavailable:
+		return nil, ErrNoAcceptableAuthMethod
+	case authUsernamePassword:
+		if err := s.authenticate(c); err != nil {
+			return nil, err
+		}
+	}
+
+	command, target, err := readRequest(c)
+	var re *ReplyError
+	switch {
+	case errors.As(err, &re):
+		s.reply(c, re.Code, nil)
+	case errors.Is(err, ErrMalformedRequest):
+		s.reply(c, statusGeneralFailure, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if command != commandTCPConnect {
+		s.reply(c, statusCommandNotSupport, nil)
+		return nil, statusErrors[statusCommandNotSupport]
+	}
+	dst, err := s.dial(ctx, "tcp", target)
+	if err != nil {
+		s.reply(c, ReplyCodeForError(err), nil)
+		return nil, err
+	}
+	if err := s.reply(c, statusRequestGranted, dst.LocalAddr()); err != nil {
+		dst.Close()
+		return nil, err
+	}
+	return dst, nil
+}
+
+// authenticate performs username/password subnegotiation
+func (s *Server) authenticate(c net.Conn) error {
+	buf := make([]byte, 2+maxCredentialLen)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != 1 { // version
+		return ErrMalformedRequest
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c, buf[:1]); err != nil {
+		return err
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+	if !s.auth(string(user), string(pass)) {
+		c.Write([]byte{1, 1})
+		return ErrAuthFailed
+	}
+	_, err := c.Write([]byte{1, 0})
+	return err
+}
+
+// reply sends reply with code and bound address, which is unspecified if
+// addr is nil
+func (s *Server) reply(c net.Conn, code byte, addr net.Addr) error {
+	host, port := "0.0.0.0", 0
+	if a, ok := addr.(*net.TCPAddr); ok {
+		host, port = a.IP.String(), a.Port
+	}
+	_, err := c.Write(appendAddr([]byte{protocolVersion, code, 0}, host, port))
+	return err
+}
+
+// readRequest reads command request and returns its command and target.
+// Unknown address type is reported as *ReplyError to send back, other
+// violations as ErrMalformedRequest.
+func readRequest(r io.Reader) (byte, string, error) {
+	buf := make([]byte, 4+1+maxDomainLen+2)
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return 0, "", err
+	}
+	if buf[0] != protocolVersion || buf[2] != 0 {
+		return 0, "", ErrMalformedRequest
+	}
+	command := buf[1]
+
+	var host string
+	switch buf[3] {
+	case addressTypeIPv4, addressTypeIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if buf[3] == addressTypeIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return 0, "", err
+		}
+		host = ip.String()
+	case addressTypeDomain:
+		if _, err := io.ReadFull(r, buf[:1]); err != nil {
+			return 0, "", err
+		}
+		if buf[0] == 0 {
+			// Empty host would be dialed as the local one
+			return 0, "", ErrMalformedRequest
+		}
+		name := buf[1 : 1+int(buf[0])]
+		if _, err := io.ReadFull(r, name); err != nil {
+			return 0, "", err
+		}
+		host = string(name)
+		// Brackets and colons would change how the target is split
+		if strings.ContainsAny(host, "[]:") {
+			return 0, "", ErrMalformedRequest
+		}
+	default:
+		return 0, "", statusErrors[statusAddressTypeNotSupported]
+	}
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return 0, "", err
+	}
+	port := int(buf[0])<<8 | int(buf[1])
+	return command, net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
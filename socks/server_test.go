@@ -0,0 +1,312 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts s on a loopback listener and returns proxy for it
+func newTestServer(t *testing.T, s *Server) (*Proxy, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() { s.Close() })
+	p, err := NewProxy(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, done
+}
+
+func TestServerConnect(t *testing.T) {
+	echo := newEchoServer(t)
+	p, _ := newTestServer(t, NewServer())
+
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if a := c.(*ProxiedConn).HandshakeInfo().BoundAddr; a.Port == 0 {
+		t.Errorf("bound address is %v", &a)
+	}
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	// Destination name is resolved by the server
+	_, port, _ := net.SplitHostPort(echo.Addr().String())
+	c2, err := p.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.Close()
+}
+
+func TestServerAuth(t *testing.T) {
+	echo := newEchoServer(t)
+	p, _ := newTestServer(t, NewServer(ServerAuth(func(user, pass string) bool {
+		return user == "user" && pass == "pass"
+	})))
+
+	if _, err := p.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrNoAcceptableAuthMethod) {
+		t.Errorf("no credentials: got %v", err)
+	}
+	p.Username, p.Password = "user", "wrong"
+	if _, err := p.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("wrong credentials: got %v", err)
+	}
+	p.Password = "pass"
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestServerReplyCodes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	p, _ := newTestServer(t, NewServer())
+	_, err = p.Dial("tcp", dead)
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != ReplyConnectionRefused {
+		t.Errorf("refused destination: got %v", err)
+	}
+
+	// Commands other than CONNECT are refused
+	a, b := net.Pipe()
+	defer a.Close()
+	done := make(chan error, 1)
+	go func() { done <- NewServer().ServeConn(b) }()
+	_, err = Client{}.Handshake(context.Background(), a, CommandUDPAssociate, "0.0.0.0:0")
+	if !errors.As(err, &re) || re.Code != ReplyCommandNotSupported {
+		t.Errorf("UDP associate: got %v", err)
+	}
+	if err := <-done; !errors.As(err, &re) || re.Code != ReplyCommandNotSupported {
+		t.Errorf("ServeConn returned %v", err)
+	}
+}
+
+func TestServerDialer(t *testing.T) {
+	blocked := errors.New("blocked")
+	var got string
+	p, _ := newTestServer(t, NewServer(ServerDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		got = addr
+		return nil, &net.OpError{Op: "dial", Net: network, Err: blocked}
+	})))
+	_, err := p.Dial("tcp", "example.com:443")
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != ReplyGeneralFailure {
+		t.Errorf("got %v", err)
+	}
+	if got != "example.com:443" {
+		t.Errorf("dialed %q", got)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	echo := newEchoServer(t)
+	s := NewServer()
+	p, done := newTestServer(t, s)
+
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s.Close()
+
+	select {
+	case err := <-done:
+		if err != ErrServerClosed {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("session read got %v, want EOF", err)
+	}
+	if err := s.ServeConn(c); err != ErrServerClosed {
+		t.Errorf("ServeConn after Close: got %v", err)
+	}
+}
+
+func TestServerEmptyDomain(t *testing.T) {
+	dialed := false
+	s := NewServer(ServerDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("unexpected dial")
+	}))
+	a, b := net.Pipe()
+	defer a.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(b) }()
+
+	a.Write([]byte{5, 1, 0})
+	io.ReadFull(a, make([]byte, 2))
+	// Server stops reading at the length, the port is left unread
+	go a.Write([]byte{5, 1, 0, 3, 0, 0, 22})
+	rep, err := ReadReply(a)
+	var re *ReplyError
+	if !errors.As(err, &re) || re.Code != ReplyGeneralFailure {
+		t.Errorf("got reply %+v, %v", rep, err)
+	}
+	if err := <-done; !errors.Is(err, ErrMalformedRequest) {
+		t.Errorf("ServeConn returned %v", err)
+	}
+	if dialed {
+		t.Error("empty host was dialed")
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     []byte
+		command byte
+		target  string
+		err     error
+	}{
+		{"ipv4", []byte{5, 1, 0, 1, 10, 0, 0, 1, 0, 80}, commandTCPConnect, "10.0.0.1:80", nil},
+		{"ipv6", append(append([]byte{5, 1, 0, 4}, net.ParseIP("2001:db8::1")...), 1, 187), commandTCPConnect, "[2001:db8::1]:443", nil},
+		{"domain", []byte{5, 3, 0, 3, 3, 'f', 'o', 'o', 0, 53}, commandUDPAssociate, "foo:53", nil},
+		{"version", []byte{4, 1, 0, 1, 10, 0, 0, 1, 0, 80}, 0, "", ErrMalformedRequest},
+		{"reserved", []byte{5, 1, 1, 1, 10, 0, 0, 1, 0, 80}, 0, "", ErrMalformedRequest},
+		{"empty domain", []byte{5, 1, 0, 3, 0, 0, 22}, 0, "", ErrMalformedRequest},
+		{"bracket in domain", []byte{5, 1, 0, 3, 3, '0', '0', ']', 0, 22}, 0, "", ErrMalformedRequest},
+		{"colon in domain", []byte{5, 1, 0, 3, 3, ':', ':', '1', 0, 22}, 0, "", ErrMalformedRequest},
+		{"address type", []byte{5, 1, 0, 2, 10, 0, 0, 1, 0, 80}, 0, "", statusErrors[statusAddressTypeNotSupported]},
+		{"short header", []byte{5, 1}, 0, "", io.ErrUnexpectedEOF},
+		{"short domain", []byte{5, 1, 0, 3, 5, 'f', 'o'}, 0, "", io.ErrUnexpectedEOF},
+		{"longest domain", append(append([]byte{5, 1, 0, 3, 255}, bytes.Repeat([]byte("x"), 255)...), 0, 80), commandTCPConnect, strings.Repeat("x", 255) + ":80", nil},
+		{"short port", []byte{5, 1, 0, 1, 10, 0, 0, 1, 0}, 0, "", io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, target, err := readRequest(bytes.NewReader(tt.req))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if command != tt.command || target != tt.target {
+				t.Errorf("got %d %q, want %d %q", command, target, tt.command, tt.target)
+			}
+		})
+	}
+}
+
+func FuzzReadRequest(f *testing.F) {
+	f.Add([]byte{5, 1, 0, 1, 10, 0, 0, 1, 0, 80})
+	f.Add([]byte{5, 1, 0, 3, 3, 'f', 'o', 'o', 0, 80})
+	f.Add(append([]byte{5, 1, 0, 4}, make([]byte, 18)...))
+	f.Add([]byte{5, 1, 0, 3, 0, 0, 22})
+	f.Add([]byte{5, 1, 0, 3, 255})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, target, err := readRequest(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			t.Fatalf("accepted request % x with invalid target %q", b, target)
+		}
+		if host == "" {
+			t.Errorf("accepted request % x without host", b)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 0xffff {
+			t.Errorf("port %q out of range", port)
+		}
+	})
+}
+
+func TestServerAuthVersion(t *testing.T) {
+	s := NewServer(ServerAuth(func(user, pass string) bool { return true }))
+	a, b := net.Pipe()
+	defer a.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeConn(b) }()
+
+	a.Write([]byte{5, 1, authUsernamePassword})
+	io.ReadFull(a, make([]byte, 2))
+	go a.Write([]byte{5, 1, 'u', 1, 'p'})
+	if err := <-done; !errors.Is(err, ErrMalformedRequest) {
+		t.Errorf("ServeConn returned %v", err)
+	}
+}
+
+// tempError is a temporary net.Error
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener fails the first n accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	n int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.n > 0 {
+		l.n--
+		return nil, tempError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerAcceptTemporary(t *testing.T) {
+	echo := newEchoServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	defer s.Close()
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(&flakyListener{Listener: l, n: 3}) }()
+
+	p, err := NewProxy(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// Permanent error ends Serve
+	l.Close()
+	select {
+	case err := <-done:
+		if err == nil || err == ErrServerClosed {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	socks4Version = 4
+
+	socks4Granted       = 90
+	socks4Rejected      = 91
+	socks4NoIdentd      = 92
+	socks4IdentMismatch = 93
+)
+
+// Version is a SOCKS protocol version spoken to the proxy
+type Version int
+
+// Protocol versions
+const (
+	// Version5 is SOCKS5, the default
+	Version5 Version = iota
+	// Version4a is SOCKS4a, supporting CONNECT to IPv4 addresses and
+	// names only, without authentication
+	Version4a
+	// VersionAuto tries SOCKS5 and falls back to SOCKS4a if the proxy
+	// doesn't speak it, remembering the working version per endpoint
+	VersionAuto
+)
+
+// versions caches protocol versions learned by VersionAuto, keyed by the
+// proxy endpoint address
+var versions sync.Map
+
+// downgradable reports whether err from SOCKS5 handshake suggests the
+// proxy speaks an older version: it either replied to the greeting with a
+// different version or closed the connection
+func downgradable(err error) bool {
+	var m *MalformedReplyError
+	if errors.As(err, &m) {
+		return len(m.Reply) == 2 && m.Reply[0] != protocolVersion
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// dial4 requests CONNECT to addr over SOCKS4a on connection c to the proxy
+func (p *Proxy) dial4(ctx context.Context, c net.Conn, network, addr string, connect time.Duration) (net.Conn, error) {
+	fail := func(err error) error {
+		c.Close()
+		return &net.OpError{Op: "socks connect", Net: network, Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+	}
+	switch network {
+	case "tcp", "tcp4":
+	case "tcp6":
+		// SOCKS4 has no IPv6 addresses, names are resolved by the proxy
+		return nil, fail(statusErrors[statusAddressTypeNotSupported])
+	default:
+		return nil, fail(ErrUnsupportedNetwork)
+	}
+	host, port, err := splitTarget(addr)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if p.OnRequest != nil {
+		_, host, port, err = Client{OnRequest: p.OnRequest}.request(CommandConnect, host, port)
+		if err != nil {
+			return nil, fail(err)
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return nil, fail(&net.AddrError{Err: "IPv6 address not supported by SOCKS4", Addr: addr})
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.SetDeadline(deadline); err != nil {
+			return nil, fail(err)
+		}
+		defer c.SetDeadline(time.Time{})
+	}
+
+	buf := []byte{socks4Version, commandTCPConnect, byte(port >> 8), byte(port)}
+	if ip != nil {
+		buf = append(buf, ip.To4()...)
+	} else {
+		buf = append(buf, 0, 0, 0, 1) // name follows the user ID
+	}
+	buf = append(append(buf, p.Username...), 0)
+	if ip == nil {
+		buf = append(append(buf, host...), 0)
+	}
+
+	start := time.Now()
+	if _, err := c.Write(buf); err != nil {
+		return nil, fail(err)
+	}
+	buf = buf[:8]
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return nil, fail(err)
+	}
+	if buf[0] != 0 {
+		return nil, fail(malformed(buf))
+	}
+	switch buf[1] {
+	case socks4Granted:
+	case socks4Rejected:
+		return nil, fail(ErrRequestRejected)
+	case socks4NoIdentd, socks4IdentMismatch:
+		return nil, fail(ErrIdentFailed)
+	default:
+		return nil, fail(malformed(buf))
+	}
+
+	info := HandshakeInfo{
+		Version:   Version4a,
+		BoundAddr: Addr{IP: net.IP(append([]byte(nil), buf[4:8]...)), Port: int(buf[2])<<8 | int(buf[3])},
+		Timings:   HandshakeTimings{Connect: connect, Request: time.Since(start)},
+	}
+	return &ProxiedConn{Conn: c, host: host, port: port, info: info}, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeProxy4 is a minimal SOCKS4a server for tests. SOCKS5 greetings are
+// answered with reply, or the connection is closed if reply is nil.
+type fakeProxy4 struct {
+	l     net.Listener
+	reply []byte
+
+	mux   sync.Mutex
+	conns int
+	users []string
+}
+
+func newFakeProxy4(t testing.TB) *fakeProxy4 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProxy4{l: l}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			p.mux.Lock()
+			p.conns++
+			p.mux.Unlock()
+			go p.handle(c)
+		}
+	}()
+	return p
+}
+
+func (p *fakeProxy4) Conns() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.conns
+}
+
+func (p *fakeProxy4) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return
+	}
+	if buf[0] != 4 {
+		c.Write(p.reply)
+		return
+	}
+	if _, err := io.ReadFull(r, buf[1:8]); err != nil {
+		return
+	}
+	user, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	p.mux.Lock()
+	p.users = append(p.users, user[:len(user)-1])
+	p.mux.Unlock()
+	host := net.IP(buf[4:8]).String()
+	if buf[4] == 0 && buf[5] == 0 && buf[6] == 0 && buf[7] != 0 {
+		name, err := r.ReadString(0)
+		if err != nil {
+			return
+		}
+		host = name[:len(name)-1]
+	}
+	port := int(buf[2])<<8 | int(buf[3])
+	d, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.Write([]byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer d.Close()
+	c.Write([]byte{0, socks4Granted, 0, 0, 0, 0, 0, 0})
+	go io.Copy(d, r)
+	io.Copy(c, d)
+}
+
+func TestDialSOCKS4a(t *testing.T) {
+	echo := newEchoServer(t)
+	port := echo.Addr().(*net.TCPAddr).Port
+	fake := newFakeProxy4(t)
+	p, err := NewProxyAuth(fake.l.Addr().String(), "ident", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Version = Version4a
+
+	for _, addr := range []string{echo.Addr().String(), net.JoinHostPort("localhost", itoa(port))} {
+		c, err := p.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if v := c.(*ProxiedConn).HandshakeInfo().Version; v != Version4a {
+			t.Errorf("%s: version is %v", addr, v)
+		}
+		c.Write([]byte("ping"))
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+			t.Errorf("%s: read %q, %v", addr, b, err)
+		}
+		c.Close()
+	}
+	if fake.users[0] != "ident" {
+		t.Errorf("user ID is %q", fake.users[0])
+	}
+
+	if _, err := p.Dial("tcp", "[::1]:80"); err == nil {
+		t.Error("IPv6 target: expected error")
+	}
+	if _, err := p.Dial("udp", echo.Addr().String()); !errors.Is(err, ErrUnsupportedNetwork) {
+		t.Errorf("udp: got %v", err)
+	}
+	if _, err := p.Dial("tcp", "127.0.0.1:1"); !errors.Is(err, ErrRequestRejected) {
+		t.Errorf("rejected: got %v", err)
+	}
+}
+
+func TestDialVersionAuto(t *testing.T) {
+	echo := newEchoServer(t)
+	tests := []struct {
+		name  string
+		reply []byte // answer to SOCKS5 greeting
+	}{
+		{"close", nil},
+		{"version mismatch", []byte{0, socks4Rejected}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeProxy4(t)
+			fake.reply = tt.reply
+			p, err := NewProxy(fake.l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Version = VersionAuto
+
+			c, err := p.Dial("tcp", echo.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+			if n := fake.Conns(); n != 2 {
+				t.Errorf("%d connections for the first dial", n)
+			}
+
+			// Version is remembered
+			c, err = p.Dial("tcp", echo.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+			if n := fake.Conns(); n != 3 {
+				t.Errorf("%d connections after the second dial", n)
+			}
+		})
+	}
+
+	t.Run("socks5", func(t *testing.T) {
+		fake := newFakeProxy(t)
+		p, err := NewProxy(fake.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Version = VersionAuto
+		c, err := p.Dial("tcp", echo.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if v := c.(*ProxiedConn).HandshakeInfo().Version; v != Version5 {
+			t.Errorf("version is %v", v)
+		}
+	})
+}
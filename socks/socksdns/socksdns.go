@@ -0,0 +1,175 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package socksdns resolves names by querying DNS servers through SOCKS5
+// proxy, so lookups leave from the proxy rather than the local host.
+//
+// Queries go over UDP association with the proxy and are retried over
+// TCP CONNECT when the response is truncated.
+package socksdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+const (
+	defaultPort = "53"
+
+	headerLen     = 12
+	maxMessageLen = 65535
+
+	flagTruncated = 0x02 // in the third byte of the header
+)
+
+// Error definitions
+var (
+	ErrShortMessage   = errors.New("DNS message shorter than header")
+	ErrMessageTooLong = errors.New("DNS message too long")
+)
+
+// NewResolver returns resolver sending all queries to server through the
+// proxy. Port 53 is assumed if server has none. The resolver uses the Go
+// implementation, which advertises EDNS0 and falls back to TCP on
+// truncated responses by itself.
+func NewResolver(p *socks.Proxy, server string) *net.Resolver {
+	server = withDefaultPort(server)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return p.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// Exchange sends DNS message msg to server through the proxy and returns
+// the response. The message is sent over UDP and retried over TCP if the
+// response comes back truncated. It's sent as is, so to receive UDP
+// responses above 512 bytes msg should carry an EDNS0 OPT record.
+func Exchange(ctx context.Context, p *socks.Proxy, server string, msg []byte) ([]byte, error) {
+	if err := checkMessage(msg); err != nil {
+		return nil, err
+	}
+	server = withDefaultPort(server)
+	resp, err := exchange(ctx, p, "udp", server, msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp[2]&flagTruncated == 0 {
+		return resp, nil
+	}
+	return exchange(ctx, p, "tcp", server, msg)
+}
+
+// ExchangeTCP sends DNS message msg to server over TCP through the proxy
+// and returns the response
+func ExchangeTCP(ctx context.Context, p *socks.Proxy, server string, msg []byte) ([]byte, error) {
+	if err := checkMessage(msg); err != nil {
+		return nil, err
+	}
+	return exchange(ctx, p, "tcp", withDefaultPort(server), msg)
+}
+
+func exchange(ctx context.Context, p *socks.Proxy, network, server string, msg []byte) ([]byte, error) {
+	c, err := p.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now()) // unblock pending I/O
+		case <-done:
+		}
+	}()
+
+	var resp []byte
+	if network == "udp" {
+		resp, err = roundTripPacket(c, msg)
+	} else {
+		resp, err = roundTripStream(c, msg)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// roundTripPacket writes msg as a single datagram and reads datagrams until
+// one with matching ID arrives
+func roundTripPacket(c net.Conn, msg []byte) ([]byte, error) {
+	_, err := c.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, maxMessageLen)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		if n >= headerLen && b[0] == msg[0] && b[1] == msg[1] {
+			return b[:n:n], nil
+		}
+	}
+}
+
+// roundTripStream writes msg with two byte length prefix and reads the
+// response framed the same way
+func roundTripStream(c net.Conn, msg []byte) ([]byte, error) {
+	b := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	_, err := c.Write(append(b, msg...))
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(c, b[:2])
+	if err != nil {
+		return nil, err
+	}
+	b = make([]byte, binary.BigEndian.Uint16(b[:2]))
+	_, err = io.ReadFull(c, b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < headerLen {
+		return nil, ErrShortMessage
+	}
+	if b[0] != msg[0] || b[1] != msg[1] {
+		return nil, errors.New("DNS response ID mismatch")
+	}
+	return b, nil
+}
+
+func checkMessage(msg []byte) error {
+	switch {
+	case len(msg) < headerLen:
+		return ErrShortMessage
+	case len(msg) > maxMessageLen:
+		return ErrMessageTooLong
+	}
+	return nil
+}
+
+// withDefaultPort adds port 53 to server if it has none
+func withDefaultPort(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), defaultPort)
+}
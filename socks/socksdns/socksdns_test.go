@@ -0,0 +1,246 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socksdns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+// newProxy starts SOCKS5 server without authentication serving CONNECT
+// and UDP ASSOCIATE to IPv4 targets
+func newProxy(t *testing.T) *socks.Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleProxy(c)
+		}
+	}()
+	p, err := socks.NewProxy(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func handleProxy(c net.Conn) {
+	defer c.Close()
+	b := make([]byte, 256)
+	io.ReadFull(c, b[:2])
+	io.ReadFull(c, b[:b[1]])
+	c.Write([]byte{5, 0})
+	if _, err := io.ReadFull(c, b[:10]); err != nil || b[3] != 1 {
+		return
+	}
+	target := &net.TCPAddr{IP: net.IP(append([]byte(nil), b[4:8]...)), Port: int(b[8])<<8 | int(b[9])}
+
+	switch b[1] {
+	case 1:
+		d, err := net.DialTCP("tcp", nil, target)
+		if err != nil {
+			c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer d.Close()
+		c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		go io.Copy(d, c)
+		io.Copy(c, d)
+	case 3:
+		u, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer u.Close()
+		port := u.LocalAddr().(*net.UDPAddr).Port
+		c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, byte(port >> 8), byte(port)})
+		go relay(u)
+		io.Copy(ioutil.Discard, c)
+	}
+}
+
+// relay forwards datagrams with IPv4 headers between the first client
+// and targets
+func relay(u *net.UDPConn) {
+	var client *net.UDPAddr
+	b := make([]byte, 65535)
+	for {
+		n, from, err := u.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			if n < 10 || b[3] != 1 {
+				continue
+			}
+			client = from
+			dst := &net.UDPAddr{IP: net.IP(append([]byte(nil), b[4:8]...)), Port: int(b[8])<<8 | int(b[9])}
+			u.WriteToUDP(b[10:n], dst)
+			continue
+		}
+		h := []byte{0, 0, 0, 1, 0, 0, 0, 0, byte(from.Port >> 8), byte(from.Port)}
+		copy(h[4:8], from.IP.To4())
+		u.WriteToUDP(append(h, b[:n]...), client)
+	}
+}
+
+// newDNSServer starts DNS server on UDP and TCP of the same port answering
+// A queries with 192.0.2.1. UDP responses are truncated if truncate is set.
+func newDNSServer(t *testing.T, truncate bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	u, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: l.Addr().(*net.TCPAddr).Port})
+	if err != nil {
+		t.Skip("UDP port taken:", err)
+	}
+	t.Cleanup(func() { u.Close() })
+
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, from, err := u.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			resp := answer(b[:n])
+			if truncate {
+				resp = resp[:headerLen]
+				resp[2] |= flagTruncated
+			}
+			u.WriteToUDP(resp, from)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				b := make([]byte, 2)
+				if _, err := io.ReadFull(c, b); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(b))
+				if _, err := io.ReadFull(c, q); err != nil {
+					return
+				}
+				resp := answer(q)
+				binary.BigEndian.PutUint16(b, uint16(len(resp)))
+				c.Write(append(b, resp...))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// answer builds response to query q with a single A record for the first
+// question, additional records of the query are dropped
+func answer(q []byte) []byte {
+	end := headerLen
+	for q[end] != 0 {
+		end += 1 + int(q[end])
+	}
+	end += 5 // root label, type and class
+	resp := append([]byte(nil), q[:end]...)
+	resp[2] |= 0x80 // response
+	resp[3] = 0
+	binary.BigEndian.PutUint16(resp[6:], 1)  // answers
+	binary.BigEndian.PutUint16(resp[8:], 0)  // authority
+	binary.BigEndian.PutUint16(resp[10:], 0) // additional
+	return append(resp,
+		0xc0, headerLen, // name pointer to the question
+		0, 1, 0, 1, // type A, class IN
+		0, 0, 0, 60, // TTL
+		0, 4, 192, 0, 2, 1)
+}
+
+// query builds A query for name
+func query(name string) []byte {
+	b := []byte{0xbe, 0xef, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0, 0, 1, 0, 1)
+}
+
+func TestExchange(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		t.Run("truncate="+strconv.FormatBool(truncate), func(t *testing.T) {
+			p := newProxy(t)
+			server := newDNSServer(t, truncate)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			resp, err := Exchange(ctx, p, server, query("example.test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp[2]&flagTruncated != 0 {
+				t.Fatal("truncated response returned")
+			}
+			if ip := net.IP(resp[len(resp)-4:]); !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+				t.Errorf("answer is %v", ip)
+			}
+		})
+	}
+}
+
+func TestExchangeShortMessage(t *testing.T) {
+	_, err := Exchange(context.Background(), nil, "127.0.0.1", []byte{1, 2})
+	if err != ErrShortMessage {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	p := newProxy(t)
+	server := newDNSServer(t, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := NewResolver(p, server).LookupIP(ctx, "ip4", "example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("resolved to %v", ips)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	for server, want := range map[string]string{
+		"1.1.1.1":        "1.1.1.1:53",
+		"1.1.1.1:5353":   "1.1.1.1:5353",
+		"2001:db8::1":    "[2001:db8::1]:53",
+		"[2001:db8::1]":  "[2001:db8::1]:53",
+		"dns.example:53": "dns.example:53",
+	} {
+		if got := withDefaultPort(server); got != want {
+			t.Errorf("%q: got %q, want %q", server, got, want)
+		}
+	}
+}
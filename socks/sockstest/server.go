@@ -0,0 +1,229 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package sockstest provides a scriptable SOCKS5 server for testing code
+// that dials through a proxy, including its retry and error handling.
+package sockstest
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+// NoAcceptableMethod is the method value rejecting all offered methods
+const NoAcceptableMethod socks.AuthMethod = 0xff
+
+// Stage is a point in the handshake
+type Stage int
+
+// Handshake stages, each is reached once the client's message is read and
+// before the server answers it
+const (
+	StageNone Stage = iota
+	StageGreeting
+	StageAuth
+	StageRequest
+)
+
+// Script describes behavior of the server on a single connection. The zero
+// value serves CONNECT without authentication.
+type Script struct {
+	// Method is the authentication method chosen regardless of the offer
+	Method socks.AuthMethod
+	// AuthFailure makes username/password authentication fail
+	AuthFailure bool
+	// Delay is waited before the command reply
+	Delay time.Duration
+	// Code is the reply code. Unless it's socks.ReplySucceeded, the
+	// connection is closed after the reply.
+	Code byte
+	// CloseAt closes the connection at the stage without answering
+	CloseAt Stage
+	// Trailing is sent right after the command reply
+	Trailing []byte
+}
+
+// Server is a SOCKS5 server listening on loopback, serving connections by
+// scripts
+type Server struct {
+	l net.Listener
+
+	mux     sync.Mutex
+	scripts []Script
+	conns   int
+}
+
+// NewServer starts server following scripts in order, one per accepted
+// connection. Once they are used up, the last one is repeated; with no
+// scripts all connections are served normally. The server is reached at
+// 127.0.0.1 and has to be closed by the caller.
+func NewServer(scripts ...Script) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("sockstest: failed to listen: " + err.Error())
+	}
+	s := &Server{l: l, scripts: scripts}
+	go s.serve()
+	return s
+}
+
+// Addr returns address the server listens at
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Proxy returns proxy pointing at the server
+func (s *Server) Proxy() *socks.Proxy {
+	return &socks.Proxy{Address: s.Addr()}
+}
+
+// Conns returns the number of connections accepted so far
+func (s *Server) Conns() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.conns
+}
+
+// Close stops the server, connections in progress are left to finish
+func (s *Server) Close() error {
+	return s.l.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c, s.script())
+	}
+}
+
+// script returns script for the next connection
+func (s *Server) script() Script {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.conns++
+	switch {
+	case len(s.scripts) == 0:
+		return Script{}
+	case s.conns <= len(s.scripts):
+		return s.scripts[s.conns-1]
+	default:
+		return s.scripts[len(s.scripts)-1]
+	}
+}
+
+func (s *Server) handle(c net.Conn, sc Script) {
+	defer c.Close()
+	b := make([]byte, 512)
+
+	if _, err := io.ReadFull(c, b[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, b[:b[1]]); err != nil {
+		return
+	}
+	if sc.CloseAt == StageGreeting {
+		return
+	}
+	c.Write([]byte{5, byte(sc.Method)})
+
+	switch sc.Method {
+	case NoAcceptableMethod:
+		return
+	case socks.AuthUsernamePassword:
+		if _, err := io.ReadFull(c, b[:2]); err != nil {
+			return
+		}
+		// Username followed by password length
+		n := int(b[1])
+		if _, err := io.ReadFull(c, b[:n+1]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, b[:b[n]]); err != nil {
+			return
+		}
+		if sc.CloseAt == StageAuth {
+			return
+		}
+		if sc.AuthFailure {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+
+	host, port, ok := readRequest(c, b)
+	if !ok || sc.CloseAt == StageRequest {
+		return
+	}
+	time.Sleep(sc.Delay)
+
+	if sc.Code != socks.ReplySucceeded {
+		c.Write([]byte{5, sc.Code, 0, 1, 0, 0, 0, 0, 0, 0})
+		c.Write(sc.Trailing)
+		return
+	}
+	if b[1] != 1 { // CONNECT
+		c.Write([]byte{5, socks.ReplyCommandNotSupported, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	d, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.Write([]byte{5, socks.ReplyCodeForError(err), 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer d.Close()
+	a := d.LocalAddr().(*net.TCPAddr)
+	rep := []byte{5, 0, 0, 1, 0, 0, 0, 0, byte(a.Port >> 8), byte(a.Port)}
+	copy(rep[4:8], a.IP.To4())
+	c.Write(append(rep, sc.Trailing...))
+	go func() {
+		io.Copy(d, c)
+		d.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(c, d)
+}
+
+// readRequest reads command request leaving its header in b
+func readRequest(c net.Conn, b []byte) (string, int, bool) {
+	if _, err := io.ReadFull(c, b[:4]); err != nil {
+		return "", 0, false
+	}
+	var host string
+	switch b[3] {
+	case 1, 4:
+		ip := make(net.IP, 4)
+		if b[3] == 4 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", 0, false
+		}
+		host = ip.String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(c, n); err != nil {
+			return "", 0, false
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return "", 0, false
+		}
+		host = string(name)
+	default:
+		return "", 0, false
+	}
+	p := make([]byte, 2)
+	if _, err := io.ReadFull(c, p); err != nil {
+		return "", 0, false
+	}
+	return host, int(p[0])<<8 | int(p[1]), true
+}
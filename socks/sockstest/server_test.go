@@ -0,0 +1,143 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package sockstest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+// newEchoServer starts TCP server echoing everything back
+func newEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestServerConnect(t *testing.T) {
+	echo := newEchoServer(t)
+	s := NewServer()
+	defer s.Close()
+
+	c, err := s.Proxy().Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "ping" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
+
+func TestServerScripts(t *testing.T) {
+	echo := newEchoServer(t)
+	tests := []struct {
+		name   string
+		script Script
+		proxy  func(p *socks.Proxy)
+		check  func(err error) bool
+	}{
+		{"reply code", Script{Code: socks.ReplyHostUnreachable}, nil, func(err error) bool {
+			var re *socks.ReplyError
+			return errors.As(err, &re) && re.Code == socks.ReplyHostUnreachable
+		}},
+		{"no method", Script{Method: NoAcceptableMethod}, nil, func(err error) bool {
+			return errors.Is(err, socks.ErrNoAcceptableAuthMethod)
+		}},
+		{"auth failure", Script{Method: socks.AuthUsernamePassword, AuthFailure: true}, func(p *socks.Proxy) {
+			p.Username, p.Password = "user", "pass"
+		}, func(err error) bool {
+			return errors.Is(err, socks.ErrAuthFailed)
+		}},
+		{"close at greeting", Script{CloseAt: StageGreeting}, nil, func(err error) bool {
+			return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		}},
+		{"close at request", Script{CloseAt: StageRequest}, nil, func(err error) bool {
+			return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		}},
+		{"delay", Script{Delay: time.Second}, nil, os.IsTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.script)
+			defer s.Close()
+			p := s.Proxy()
+			if tt.proxy != nil {
+				tt.proxy(p)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			c, err := p.DialContext(ctx, "tcp", echo.Addr().String())
+			if err == nil {
+				c.Close()
+				t.Fatal("expected error")
+			}
+			if !tt.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestServerTrailing(t *testing.T) {
+	echo := newEchoServer(t)
+	s := NewServer(Script{Trailing: []byte("junk")})
+	defer s.Close()
+
+	c, err := s.Proxy().Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "junk" {
+		t.Errorf("read %q, %v", b, err)
+	}
+}
+
+func TestServerSequence(t *testing.T) {
+	echo := newEchoServer(t)
+	s := NewServer(Script{Code: socks.ReplyGeneralFailure}, Script{})
+	defer s.Close()
+
+	p := s.Proxy()
+	if _, err := p.Dial("tcp", echo.Addr().String()); err == nil {
+		t.Fatal("first connection: expected error")
+	}
+	for i := 0; i < 2; i++ {
+		c, err := p.Dial("tcp", echo.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	if s.Conns() != 3 {
+		t.Errorf("accepted %d connections", s.Conns())
+	}
+}
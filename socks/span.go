@@ -0,0 +1,55 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// DialSpan is a tracing span covering a single dial through the proxy
+type DialSpan interface {
+	// End is called once the dial is done. Proxy is the proxy endpoint
+	// used, nil if none was reached, and info is zero if the dial failed.
+	// If the proxy rejected the request, err matches *ReplyError carrying
+	// the reply code.
+	End(proxy net.Addr, info HandshakeInfo, err error)
+}
+
+// StartSpanFunc starts a span for dialing addr over network, as a child of
+// the span carried by ctx if any. It's meant to adapt a tracing system,
+// such as OpenTelemetry, without this package depending on it.
+type StartSpanFunc func(ctx context.Context, network, addr string) DialSpan
+
+// DialerTracing is an option to trace dials with spans started by start.
+// The span covers the handshake only, as the connection to the proxy is
+// already established; Timings.Connect of the reported info tells how long
+// connecting took if the dialer came from Proxy.
+func DialerTracing(start StartSpanFunc) DialerOption {
+	return func(d *Dialer) error {
+		d.span = start
+		return nil
+	}
+}
+
+// endSpan ends span with the outcome of a dial
+func endSpan(span DialSpan, conn net.Conn, err error) {
+	var (
+		proxy net.Addr
+		info  HandshakeInfo
+	)
+	switch c := conn.(type) {
+	case *ProxiedConn:
+		proxy, info = c.Proxy(), c.HandshakeInfo()
+	case *UDPConn:
+		proxy, info = c.Proxy(), c.HandshakeInfo()
+	}
+	var op *net.OpError
+	if errors.As(err, &op) {
+		proxy = op.Addr
+	}
+	span.End(proxy, info, err)
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	parent string
+	addr   string
+	ended  bool
+	proxy  net.Addr
+	info   HandshakeInfo
+	err    error
+}
+
+func (s *testSpan) End(proxy net.Addr, info HandshakeInfo, err error) {
+	s.ended, s.proxy, s.info, s.err = true, proxy, info, err
+}
+
+func TestProxyStartSpan(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spans []*testSpan
+	p.StartSpan = func(ctx context.Context, network, addr string) DialSpan {
+		parent, _ := ctx.Value(spanKey{}).(string)
+		s := &testSpan{parent: parent, addr: addr}
+		spans = append(spans, s)
+		return s
+	}
+	ctx := context.WithValue(context.Background(), spanKey{}, "parent")
+
+	c, err := p.DialContext(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	fake.reply = []byte{5, ReplyHostUnreachable, 0, 1, 0, 0, 0, 0, 0, 0}
+	if _, err := p.DialContext(ctx, "tcp", echo.Addr().String()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("%d spans started", len(spans))
+	}
+	ok := spans[0]
+	if !ok.ended || ok.parent != "parent" || ok.addr != echo.Addr().String() || ok.err != nil {
+		t.Errorf("span of successful dial is %+v", ok)
+	}
+	if ok.proxy == nil || ok.proxy.String() != fake.Addr() || ok.info.Timings.Connect <= 0 {
+		t.Errorf("proxy %v, info %+v", ok.proxy, ok.info)
+	}
+	failed := spans[1]
+	var re *ReplyError
+	if !failed.ended || !errors.As(failed.err, &re) || re.Code != ReplyHostUnreachable {
+		t.Errorf("span of failed dial ended with %v", failed.err)
+	}
+	if failed.proxy == nil || failed.proxy.String() != fake.Addr() {
+		t.Errorf("failed dial proxy is %v", failed.proxy)
+	}
+}
+
+func TestDialerTracing(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	c, err := net.Dial("tcp", fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := &testSpan{}
+	d, err := NewDialer(c, DialerTracing(func(ctx context.Context, network, addr string) DialSpan {
+		return span
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !span.ended || span.err != nil || span.info.Timings.Request <= 0 {
+		t.Errorf("span is %+v", span)
+	}
+}
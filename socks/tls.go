@@ -0,0 +1,50 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// DialTLSContext dials addr through the proxy and performs TLS handshake
+// with the target over the tunnel. Unless config sets ServerName, the host
+// from addr is used for SNI and certificate verification, so the target is
+// verified by the name it was dialed with, never by the proxy address.
+func (p *Proxy) DialTLSContext(ctx context.Context, network, addr string, config *tls.Config) (*tls.Conn, error) {
+	c, err := p.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := tlsClient(ctx, c, addr, config)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// tlsClient performs TLS handshake over c with the target at addr
+func tlsClient(ctx context.Context, c net.Conn, addr string, config *tls.Config) (*tls.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	tc := tls.Client(c, config)
+	err := tc.HandshakeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
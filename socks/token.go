@@ -0,0 +1,73 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// TokenProvider supplies short-lived tokens sent to the proxy as the
+// password. The token is cached until the proxy rejects it, then a fresh
+// one is fetched and the dial is retried once.
+type TokenProvider struct {
+	// Fetch obtains a new token
+	Fetch func(ctx context.Context) (string, error)
+
+	mux   sync.Mutex
+	token string
+}
+
+// Token returns the cached token, fetching one if there is none
+func (t *TokenProvider) Token(ctx context.Context) (string, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.token != "" {
+		return t.token, nil
+	}
+	return t.fetch(ctx)
+}
+
+// Refresh replaces the token stale rejected by the proxy. If it has already
+// been replaced by a concurrent dial, the newer token is returned as is.
+func (t *TokenProvider) Refresh(ctx context.Context, stale string) (string, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.token != stale && t.token != "" {
+		return t.token, nil
+	}
+	return t.fetch(ctx)
+}
+
+func (t *TokenProvider) fetch(ctx context.Context) (string, error) {
+	token, err := t.Fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	t.token = token
+	return token, nil
+}
+
+// withToken dials addr authenticating with a token from Token, refreshing
+// it once if the proxy rejects it
+func (p *Proxy) withToken(ctx context.Context, dial func(ctx context.Context) (net.Conn, error), network, addr string, opts ...DialerOption) (net.Conn, error) {
+	token, err := p.Token.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	auth := append(opts[:len(opts):len(opts)], DialerAuth(p.Username, token))
+	conn, err := p.attempt(ctx, dial, network, addr, auth...)
+	if !errors.Is(err, ErrAuthFailed) || ctx.Err() != nil {
+		return conn, err
+	}
+	token, err = p.Token.Refresh(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	auth[len(auth)-1] = DialerAuth(p.Username, token)
+	return p.attempt(ctx, dial, network, addr, auth...)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestProxyToken(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "client", "token1"
+
+	fetched := 0
+	tokens := &TokenProvider{Fetch: func(ctx context.Context) (string, error) {
+		fetched++
+		return "token" + strconv.Itoa(fetched), nil
+	}}
+	p, err := NewProxyAuth(fake.Addr(), "client", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Token = tokens
+
+	for i := 0; i < 2; i++ {
+		c, err := p.Dial("tcp", echo.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	if fetched != 1 {
+		t.Errorf("fetched %d tokens, want cached one", fetched)
+	}
+
+	// Token expires, the dial refreshes it
+	fake.pass = "token2"
+	c, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if fetched != 2 {
+		t.Errorf("fetched %d tokens", fetched)
+	}
+
+	// Refreshed token rejected too, only one retry is made
+	fake.pass = "token9"
+	if _, err := p.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("got %v", err)
+	}
+	if fetched != 3 {
+		t.Errorf("fetched %d tokens", fetched)
+	}
+
+	fail := errors.New("token service down")
+	p.Token = &TokenProvider{Fetch: func(ctx context.Context) (string, error) { return "", fail }}
+	if _, err := p.Dial("tcp", echo.Addr().String()); !errors.Is(err, fail) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestTokenProviderRefresh(t *testing.T) {
+	n := 0
+	tp := &TokenProvider{Fetch: func(ctx context.Context) (string, error) {
+		n++
+		return strconv.Itoa(n), nil
+	}}
+	ctx := context.Background()
+	stale, _ := tp.Token(ctx)
+	fresh, _ := tp.Refresh(ctx, stale)
+	// Another dial holding the same stale token gets the fresh one
+	again, _ := tp.Refresh(ctx, stale)
+	if stale != "1" || fresh != "2" || again != "2" {
+		t.Errorf("tokens are %q, %q, %q", stale, fresh, again)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+// Package torhttp provides HTTP transport isolating requests onto separate
+// Tor circuits.
+//
+// Tor puts streams opened with different SOCKS credentials onto different
+// circuits. Transport picks credentials per request, so unlike
+// socks.Proxy with TorIsolation, which isolates per dial, every request or
+// every group of requests sharing a key leaves through its own exit.
+package torhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+// Transport is http.RoundTripper sending requests through Tor with
+// per-request circuit isolation. Connections are never reused between
+// requests, as that would share a circuit.
+type Transport struct {
+	// Proxy is the Tor SOCKS port. Its credentials and TorIsolation are
+	// ignored, the transport sets its own.
+	Proxy *socks.Proxy
+
+	// Key, if set, returns isolation key of the request. Requests with the
+	// same key share credentials and so the circuit. If nil, every request
+	// gets fresh credentials.
+	Key func(r *http.Request) string
+
+	// Rand, if set, is the entropy source for credentials and the key salt
+	// instead of crypto/rand. It must be safe for concurrent use.
+	Rand io.Reader
+
+	once sync.Once
+	salt []byte
+	err  error
+	t    *http.Transport
+}
+
+type credentials struct {
+	user, pass string
+}
+
+type credentialsKey struct{}
+
+func (t *Transport) init() {
+	t.salt = make([]byte, 16)
+	_, t.err = io.ReadFull(t.rand(), t.salt)
+	t.t = &http.Transport{
+		DialContext:           t.dial,
+		DisableKeepAlives:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func (t *Transport) rand() io.Reader {
+	if t.Rand != nil {
+		return t.Rand
+	}
+	return rand.Reader
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.once.Do(t.init)
+	if t.err != nil {
+		return nil, t.err
+	}
+	c, err := t.credentials(r)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(context.WithValue(r.Context(), credentialsKey{}, c))
+	return t.t.RoundTrip(r)
+}
+
+// credentials returns random credentials or, if Key is set, credentials
+// derived from the request key and salt unique to the transport
+func (t *Transport) credentials(r *http.Request) (credentials, error) {
+	b := make([]byte, 16)
+	if t.Key == nil {
+		_, err := io.ReadFull(t.rand(), b)
+		if err != nil {
+			return credentials{}, err
+		}
+	} else {
+		h := sha256.New()
+		h.Write(t.salt)
+		io.WriteString(h, t.Key(r))
+		b = h.Sum(nil)
+	}
+	return credentials{hex.EncodeToString(b[0:8]), hex.EncodeToString(b[8:16])}, nil
+}
+
+func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	p := *t.Proxy
+	p.TorIsolation = false
+	if c, ok := ctx.Value(credentialsKey{}).(credentials); ok {
+		p.Username, p.Password = c.user, c.pass
+	}
+	return p.DialContext(ctx, network, addr)
+}
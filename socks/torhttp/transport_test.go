@@ -0,0 +1,163 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package torhttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/akabos/go-socks/socks"
+)
+
+// fakeTor is a SOCKS5 server accepting any username/password and
+// recording the usernames, which Tor uses for circuit isolation
+type fakeTor struct {
+	l     net.Listener
+	mux   sync.Mutex
+	users []string
+}
+
+func newFakeTor(t *testing.T) *fakeTor {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	f := &fakeTor{l: l}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.handle(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeTor) handle(c net.Conn) {
+	defer c.Close()
+	b := make([]byte, 256)
+	io.ReadFull(c, b[:2])
+	io.ReadFull(c, b[:b[1]])
+	c.Write([]byte{5, 2})
+
+	io.ReadFull(c, b[:2])
+	user := make([]byte, b[1])
+	io.ReadFull(c, user)
+	io.ReadFull(c, b[:1])
+	io.ReadFull(c, b[:b[0]])
+	c.Write([]byte{1, 0})
+	f.mux.Lock()
+	f.users = append(f.users, string(user))
+	f.mux.Unlock()
+
+	// Only IPv4 targets are used in tests
+	if _, err := io.ReadFull(c, b[:10]); err != nil {
+		return
+	}
+	addr := net.JoinHostPort(net.IP(b[4:8]).String(), strconv.Itoa(int(b[8])<<8|int(b[9])))
+	d, err := net.Dial("tcp", addr)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer d.Close()
+	c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(d, c)
+	io.Copy(c, d)
+}
+
+func (f *fakeTor) Users() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]string(nil), f.users...)
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	tests := []struct {
+		name  string
+		key   func(r *http.Request) string
+		paths []string
+		same  [][2]int // pairs of requests expected to share credentials
+		diff  [][2]int // pairs expected to differ
+	}{
+		{"per request", nil, []string{"/a", "/a"}, nil, [][2]int{{0, 1}}},
+		{"per key", func(r *http.Request) string { return r.URL.Path }, []string{"/a", "/b", "/a"}, [][2]int{{0, 2}}, [][2]int{{0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tor := newFakeTor(t)
+			p, err := socks.NewProxy(tor.l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &http.Client{Transport: &Transport{Proxy: p, Key: tt.key}}
+			for _, path := range tt.paths {
+				resp, err := c.Get(srv.URL + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			users := tor.Users()
+			if len(users) != len(tt.paths) {
+				t.Fatalf("%d dials for %d requests", len(users), len(tt.paths))
+			}
+			for _, s := range tt.same {
+				if users[s[0]] != users[s[1]] {
+					t.Errorf("requests %d and %d are isolated", s[0], s[1])
+				}
+			}
+			for _, d := range tt.diff {
+				if users[d[0]] == users[d[1]] {
+					t.Errorf("requests %d and %d share credentials %q", d[0], d[1], users[d[0]])
+				}
+			}
+		})
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestTransportRand(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	tor := newFakeTor(t)
+	p, err := socks.NewProxy(tor.l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &http.Client{Transport: &Transport{Proxy: p, Rand: zeroReader{}}}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	for _, u := range tor.Users() {
+		if u != "0000000000000000" {
+			t.Errorf("user is %q", u)
+		}
+	}
+}
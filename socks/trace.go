@@ -0,0 +1,81 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// DialerTrace is an option to write a hex dump of handshake traffic to w.
+// Payload relayed after the handshake is not traced, username and password
+// bytes are masked.
+func DialerTrace(w io.Writer) DialerOption {
+	return func(d *Dialer) error {
+		d.client.Trace = w
+		return nil
+	}
+}
+
+// traceConn dumps everything read from and written to the connection
+type traceConn struct {
+	net.Conn
+	w io.Writer
+
+	// redact, if set, masks sensitive bytes of the next write in the dump
+	redact func([]byte) []byte
+}
+
+func (c *traceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.dump("<<", "from", b[:n])
+	}
+	return n, err
+}
+
+func (c *traceConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		d := b[:n]
+		if c.redact != nil {
+			d = c.redact(d)
+			c.redact = nil
+		}
+		c.dump(">>", "to", d)
+	}
+	return n, err
+}
+
+func (c *traceConn) dump(dir, prep string, b []byte) {
+	fmt.Fprintf(c.w, "%s %s %d bytes %s %s\n%s",
+		time.Now().Format("15:04:05.000000"), dir, len(b), prep, c.RemoteAddr(), hex.Dump(b))
+}
+
+// redactCredentials returns copy of username/password request with the
+// credentials masked, lengths are kept
+func redactCredentials(b []byte) []byte {
+	r := append([]byte(nil), b...)
+	if len(r) < 2 {
+		return r
+	}
+	i := 2 + int(r[1])
+	if i >= len(r) {
+		mask(r[2:])
+		return r
+	}
+	mask(r[2:i])
+	mask(r[i+1:])
+	return r
+}
+
+func mask(b []byte) {
+	for i := range b {
+		b[i] = '*'
+	}
+}
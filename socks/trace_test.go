@@ -0,0 +1,70 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDialerTrace(t *testing.T) {
+	echo := newEchoServer(t)
+	fake := newFakeProxy(t)
+	fake.user, fake.pass = "alice", "s3cret"
+
+	c, err := net.Dial("tcp", fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d, err := NewDialer(c, DialerAuth("alice", "s3cret"), DialerTrace(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	dump := out.String()
+	if !strings.Contains(dump, ">>") || !strings.Contains(dump, "<<") {
+		t.Errorf("dump lacks direction markers:\n%s", dump)
+	}
+	for _, secret := range []string{"alice", "s3cret"} {
+		if strings.Contains(dump, hex.EncodeToString([]byte(secret))[:8]) {
+			t.Errorf("dump leaks %q:\n%s", secret, dump)
+		}
+	}
+
+	// Payload is not traced
+	n := out.Len()
+	pc.Write([]byte("ping"))
+	if out.Len() != n {
+		t.Error("payload written to trace")
+	}
+}
+
+func TestRedactCredentials(t *testing.T) {
+	for _, b := range [][]byte{
+		{1, 3, 'a', 'b', 'c', 2, 'd', 'e'},
+		{1, 3, 'a'},
+		{1},
+		{1, 0, 0},
+	} {
+		r := redactCredentials(b)
+		if len(r) != len(b) {
+			t.Fatalf("length changed: % x", r)
+		}
+		for _, x := range r {
+			if x >= 'a' && x <= 'e' {
+				t.Errorf("% x not masked", r)
+			}
+		}
+	}
+}
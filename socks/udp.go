@@ -0,0 +1,399 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxUDPHeaderLen is the length of UDP request header with the longest
+// domain name
+const maxUDPHeaderLen = 4 + 1 + maxDomainLen + 2
+
+// UDPConn is a datagram connection relayed through UDP association with
+// the proxy. As net.Conn it exchanges datagrams with the dialed target;
+// as net.PacketConn it can reach any destination allowed by the proxy.
+//
+// The association lasts as long as the control connection to the proxy,
+// once the proxy closes it the UDPConn is closed too, unless it was dialed
+// by Proxy with UDPReassociate set. Datagrams fragmented by the proxy are
+// dropped.
+type UDPConn struct {
+	target *Addr
+
+	mux       sync.Mutex // guards the fields below
+	ctrl      net.Conn
+	conn      *net.UDPConn
+	info      HandshakeInfo
+	closed    bool
+	rdeadline time.Time
+	wdeadline time.Time
+	redial    func(ctx context.Context) (net.Conn, error)
+	client    Client
+	notify    func(relay net.Addr)
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	rmux sync.Mutex
+	rbuf []byte
+	peer net.IP // learned address of the target dialed by name
+}
+
+// associate requests UDP association over the control connection and
+// connects to the relay address the proxy replies with
+func (c Client) associate(ctx context.Context, ctrl net.Conn, target string) (*UDPConn, error) {
+	host, port, err := splitTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	conn, rep, err := c.relay(ctx, ctrl)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &UDPConn{
+		ctrl:   ctrl,
+		conn:   conn,
+		target: &Addr{Name: host, Port: port},
+		info:   rep.info(),
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		u.target = &Addr{IP: ip, Port: port}
+	}
+	go u.watch(ctrl)
+	return u, nil
+}
+
+// relay requests UDP association over ctrl and returns socket connected
+// to the relay
+func (c Client) relay(ctx context.Context, ctrl net.Conn) (*net.UDPConn, *Reply, error) {
+	// Address of the datagram sender isn't known before the socket is
+	// bound to the relay address, so it's left unspecified
+	rep, err := c.Handshake(ctx, ctrl, CommandUDPAssociate, "0.0.0.0:0")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relay := &net.UDPAddr{IP: rep.BoundAddr.IP, Port: rep.BoundAddr.Port}
+	if rep.BoundAddr.Name != "" {
+		relay, err = net.ResolveUDPAddr("udp", rep.BoundAddr.String())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if relay.IP.IsUnspecified() {
+		// Proxy listens on all interfaces, use the one we're talking to
+		if a, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP = a.IP
+		}
+	}
+
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, rep, nil
+}
+
+// reassociate makes the connection establish a new association over a
+// control connection from redial whenever the current one is lost
+func (c *UDPConn) reassociate(redial func(ctx context.Context) (net.Conn, error), client Client, notify func(relay net.Addr)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.redial = redial
+	c.client = client
+	c.notify = notify
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+}
+
+// watch closes datagram socket or replaces the association once control
+// connection is gone
+func (c *UDPConn) watch(ctrl net.Conn) {
+	io.Copy(ioutil.Discard, ctrl)
+
+	c.mux.Lock()
+	redial, closed := c.redial, c.closed
+	c.mux.Unlock()
+	if closed {
+		return
+	}
+	if redial == nil {
+		c.socket().Close()
+		return
+	}
+
+	ctrl, conn, rep, err := c.associateAgain()
+	if err != nil {
+		c.socket().Close()
+		return
+	}
+
+	c.mux.Lock()
+	if c.closed {
+		c.mux.Unlock()
+		conn.Close()
+		ctrl.Close()
+		return
+	}
+	old := c.conn
+	c.ctrl, c.conn, c.info = ctrl, conn, rep.info()
+	conn.SetReadDeadline(c.rdeadline)
+	conn.SetWriteDeadline(c.wdeadline)
+	notify := c.notify
+	c.mux.Unlock()
+
+	// Unblocks pending reads, which carry on with the new socket
+	old.Close()
+	go c.watch(ctrl)
+	notify(conn.RemoteAddr())
+}
+
+func (c *UDPConn) associateAgain() (net.Conn, *net.UDPConn, *Reply, error) {
+	c.mux.Lock()
+	ctx, redial, client := c.ctx, c.redial, c.client
+	c.mux.Unlock()
+
+	ctrl, err := redial(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	conn, rep, err := client.relay(ctx, ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, nil, nil, err
+	}
+	return ctrl, conn, rep, nil
+}
+
+// socket returns the current datagram socket
+func (c *UDPConn) socket() *net.UDPConn {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.conn
+}
+
+// Read reads payload of the next datagram from the dialed target,
+// datagrams from other sources are discarded. Relays report sources by IP,
+// so for a target dialed by name the first source on the target port is
+// taken to be the target.
+func (c *UDPConn) Read(b []byte) (int, error) {
+	c.rmux.Lock()
+	defer c.rmux.Unlock()
+	for {
+		n, addr, err := c.readFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		if c.fromTarget(addr) {
+			return n, nil
+		}
+	}
+}
+
+// ReadFrom reads payload of the next datagram and returns its source as
+// reported by the proxy
+func (c *UDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.rmux.Lock()
+	defer c.rmux.Unlock()
+	n, addr, err := c.readFrom(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, addr, nil
+}
+
+func (c *UDPConn) readFrom(b []byte) (int, *Addr, error) {
+	if cap(c.rbuf) < maxUDPHeaderLen+len(b) {
+		c.rbuf = make([]byte, maxUDPHeaderLen+len(b))
+	}
+	buf := c.rbuf[:maxUDPHeaderLen+len(b)]
+	for {
+		conn := c.socket()
+		n, err := conn.Read(buf)
+		if err != nil {
+			if c.socket() != conn {
+				continue // association was replaced
+			}
+			return 0, nil, err
+		}
+		addr, off, ok := parseUDPHeader(buf[:n])
+		if !ok {
+			continue
+		}
+		return copy(b, buf[off:n]), addr, nil
+	}
+}
+
+// fromTarget reports whether a is the dialed target, must be called with
+// rmux held
+func (c *UDPConn) fromTarget(a *Addr) bool {
+	t := c.target
+	switch {
+	case a.Port != t.Port:
+		return false
+	case t.IP != nil:
+		return t.IP.Equal(a.IP)
+	case a.Name != "":
+		return a.Name == t.Name
+	case c.peer == nil:
+		c.peer = a.IP
+		return true
+	default:
+		return c.peer.Equal(a.IP)
+	}
+}
+
+// Write sends b to the dialed target
+func (c *UDPConn) Write(b []byte) (int, error) {
+	return c.WriteTo(b, c.target)
+}
+
+// WriteTo sends b to addr
+func (c *UDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	host, port, err := splitTarget(addr.String())
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 3, maxUDPHeaderLen+len(b))
+	buf = appendAddr(buf, host, port)
+	buf = append(buf, b...)
+	conn := c.socket()
+	_, err = conn.Write(buf)
+	if err != nil && c.socket() != conn {
+		_, err = c.socket().Write(buf) // association was replaced
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close terminates the association
+func (c *UDPConn) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.closed = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+	err := c.conn.Close()
+	c.ctrl.Close()
+	return err
+}
+
+// LocalAddr returns local address of the datagram socket
+func (c *UDPConn) LocalAddr() net.Addr {
+	return c.socket().LocalAddr()
+}
+
+// RemoteAddr returns the dialed target address
+func (c *UDPConn) RemoteAddr() net.Addr {
+	return c.target
+}
+
+// SetDeadline sets read and write deadlines of the datagram socket
+func (c *UDPConn) SetDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.rdeadline, c.wdeadline = t, t
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets read deadline of the datagram socket
+func (c *UDPConn) SetReadDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.rdeadline = t
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets write deadline of the datagram socket
+func (c *UDPConn) SetWriteDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.wdeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Proxy returns address of the proxy holding the association
+func (c *UDPConn) Proxy() net.Addr {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.ctrl.RemoteAddr()
+}
+
+// AuthMethod returns authentication method negotiated with the proxy
+func (c *UDPConn) AuthMethod() AuthMethod {
+	return c.HandshakeInfo().Method
+}
+
+// HandshakeInfo returns details of the negotiation with the proxy, the
+// bound address is the relay address as reported by the proxy
+func (c *UDPConn) HandshakeInfo() HandshakeInfo {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.info
+}
+
+// appendAddr appends address type, address and port to b, IP literals are
+// encoded as such, anything else as a domain name
+func appendAddr(b []byte, host string, port int) []byte {
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		b = append(b, addressTypeDomain, byte(len(host)))
+		b = append(b, host...)
+	case ip.To4() != nil:
+		b = append(b, addressTypeIPv4)
+		b = append(b, ip.To4()...)
+	default:
+		b = append(b, addressTypeIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return append(b, byte(port>>8), byte(port&0xff))
+}
+
+// parseUDPHeader parses header of a datagram received from the relay and
+// returns source address and payload offset
+func parseUDPHeader(b []byte) (*Addr, int, bool) {
+	if len(b) < 4 || b[0] != 0 || b[1] != 0 || b[2] != 0 {
+		return nil, 0, false
+	}
+	addr := &Addr{}
+	off := 4
+	switch b[3] {
+	default:
+		return nil, 0, false
+	case addressTypeIPv4:
+		off += net.IPv4len
+		if len(b) < off+2 {
+			return nil, 0, false
+		}
+		addr.IP = net.IP(append([]byte(nil), b[4:off]...))
+	case addressTypeIPv6:
+		off += net.IPv6len
+		if len(b) < off+2 {
+			return nil, 0, false
+		}
+		addr.IP = net.IP(append([]byte(nil), b[4:off]...))
+	case addressTypeDomain:
+		if len(b) < 5 || b[4] == 0 {
+			return nil, 0, false
+		}
+		off += 1 + int(b[4])
+		if len(b) < off+2 {
+			return nil, 0, false
+		}
+		addr.Name = string(b[5:off])
+	}
+	addr.Port = int(b[off])<<8 | int(b[off+1])
+	return addr, off + 2, true
+}
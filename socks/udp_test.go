@@ -0,0 +1,258 @@
+// Copyright 2017 Mikhail Lukyanchenko. All rights reserved.
+// Use of this source code is governed by a 3-clause BSD
+// license that can be found in the LICENSE file.
+
+package socks
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseUDPHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      []byte
+		addr   string
+		off    int
+		parsed bool
+	}{
+		{"ipv4", []byte{0, 0, 0, 1, 10, 0, 0, 1, 0, 53, 'x'}, "10.0.0.1:53", 10, true},
+		{"ipv6", append(append([]byte{0, 0, 0, 4}, net.ParseIP("::1")...), 0, 53), "[::1]:53", 22, true},
+		{"domain", []byte{0, 0, 0, 3, 3, 'f', 'o', 'o', 0, 53}, "foo:53", 10, true},
+		{"empty", nil, "", 0, false},
+		{"short header", []byte{0, 0, 0}, "", 0, false},
+		{"reserved", []byte{0, 1, 0, 1, 10, 0, 0, 1, 0, 53}, "", 0, false},
+		{"fragment", []byte{0, 0, 1, 1, 10, 0, 0, 1, 0, 53}, "", 0, false},
+		{"unknown type", []byte{0, 0, 0, 2, 10, 0, 0, 1, 0, 53}, "", 0, false},
+		{"truncated ipv4", []byte{0, 0, 0, 1, 10, 0, 0, 1, 0}, "", 0, false},
+		{"truncated ipv6", []byte{0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0}, "", 0, false},
+		{"truncated domain", []byte{0, 0, 0, 3, 9, 'f', 'o', 'o', 0, 53}, "", 0, false},
+		{"missing domain length", []byte{0, 0, 0, 3}, "", 0, false},
+		{"empty domain", []byte{0, 0, 0, 3, 0, 0, 53}, "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, off, ok := parseUDPHeader(tt.b)
+			if ok != tt.parsed {
+				t.Fatalf("parsed is %v, want %v", ok, tt.parsed)
+			}
+			if !ok {
+				return
+			}
+			if addr.String() != tt.addr || off != tt.off {
+				t.Errorf("got %v at %d, want %v at %d", addr, off, tt.addr, tt.off)
+			}
+		})
+	}
+}
+
+func TestAppendAddrRoundTrip(t *testing.T) {
+	for _, host := range []string{"10.0.0.1", "::1", "example.com"} {
+		b := appendAddr([]byte{0, 0, 0}, host, 5353)
+		addr, off, ok := parseUDPHeader(b)
+		if !ok || off != len(b) {
+			t.Fatalf("%s: can't parse % x", host, b)
+		}
+		if want := net.JoinHostPort(host, "5353"); addr.String() != want {
+			t.Errorf("got %v, want %v", addr, want)
+		}
+	}
+}
+
+func FuzzParseUDPHeader(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 10, 0, 0, 1, 0, 53})
+	f.Add([]byte{0, 0, 0, 3, 3, 'f', 'o', 'o', 0, 53})
+	f.Add(append([]byte{0, 0, 0, 4}, make([]byte, 18)...))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		addr, off, ok := parseUDPHeader(b)
+		if !ok {
+			return
+		}
+		if off > len(b) {
+			t.Fatalf("offset %d past %d bytes", off, len(b))
+		}
+		// Re-encoding must produce the same header
+		host := addr.Name
+		if host == "" {
+			host = addr.IP.String()
+		}
+		if addr.Name != "" && net.ParseIP(addr.Name) != nil || addr.IP != nil && b[3] == addressTypeIPv6 && addr.IP.To4() != nil {
+			return // encoded differently, as IP and as IPv4 respectively
+		}
+		if h := appendAddr([]byte{0, 0, 0}, host, addr.Port); !bytes.Equal(h, b[:off]) {
+			t.Errorf("re-encoded % x as % x", b[:off], h)
+		}
+	})
+}
+
+// newUDPEchoServer starts UDP server echoing datagrams back
+func newUDPEchoServer(t testing.TB) *net.UDPConn {
+	u, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { u.Close() })
+	go func() {
+		b := make([]byte, 65535)
+		for {
+			n, a, err := u.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			u.WriteTo(b[:n], a)
+		}
+	}()
+	return u
+}
+
+func TestUDPAssociate(t *testing.T) {
+	echo := newUDPEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	u := c.(*UDPConn)
+	u.SetDeadline(time.Now().Add(5 * time.Second))
+
+	stranger, err := net.DialUDP("udp", nil, u.conn.RemoteAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+
+	b := make([]byte, 64)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		n, err := c.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b[:n]) != "ping" {
+			t.Errorf("read %q from the target", b[:n])
+		}
+		if i > 0 {
+			break
+		}
+		// Once the relay knows us, a stranger's datagram is queued ahead
+		// of the next reply and must be skipped by Read
+		stranger.Write([]byte("noise"))
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// ReadFrom sees every source
+	stranger.Write([]byte("noise"))
+	n, from, err := u.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "noise" || from.String() != stranger.LocalAddr().String() {
+		t.Errorf("read %q from %v", b[:n], from)
+	}
+
+	if _, err := u.WriteTo([]byte("x"), &Addr{IP: net.IPv4(127, 0, 0, 1), Port: 70000}); err == nil {
+		t.Error("expected error for port out of range")
+	}
+}
+
+func TestUDPReassociate(t *testing.T) {
+	echo := newUDPEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	relays := make(chan net.Addr, 1)
+	p.UDPReassociate = func(relay net.Addr) { relays <- relay }
+
+	c, err := p.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	old := c.(*UDPConn).HandshakeInfo().BoundAddr
+
+	ping := func() {
+		t.Helper()
+		if _, err := c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 64)
+		n, err := c.Read(b)
+		if err != nil || string(b[:n]) != "ping" {
+			t.Fatalf("read %q, %v", b[:n], err)
+		}
+	}
+	ping()
+
+	// A read pending while the association is lost continues on the new one
+	done := make(chan error, 1)
+	go func() {
+		b := make([]byte, 64)
+		_, err := c.Read(b)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	fake.drop()
+
+	select {
+	case relay := <-relays:
+		if relay.(*net.UDPAddr).Port == old.Port {
+			t.Errorf("relay address %v didn't change", relay)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reassociated")
+	}
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	ping()
+}
+
+func TestUDPControlLost(t *testing.T) {
+	echo := newUDPEchoServer(t)
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Dial("udp", echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fake.drop()
+	var ne net.Error
+	if _, err := c.Read(make([]byte, 64)); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+}
+
+func TestDialUnsupportedNetwork(t *testing.T) {
+	fake := newFakeProxy(t)
+	p, err := NewProxy(fake.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Dial("unix", "/tmp/sock:1")
+	if !errors.Is(err, ErrUnsupportedNetwork) {
+		t.Errorf("expected ErrUnsupportedNetwork, got %v", err)
+	}
+}